@@ -0,0 +1,97 @@
+package reflectutils
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"time"
+
+	"github.com/memsql/errors"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Types from the standard library that implement encoding.TextUnmarshaler,
+// like net.IP, netip.Addr, netip.Prefix, big.Int, big.Float, and big.Rat,
+// do not need to be registered.  The rest are registered here.  os.FileMode
+// is not registered: it is an integer like any other so "base=8" or
+// WithIntegerBase(8) parses it as octal.
+func init() {
+	RegisterStringSetterFor(time.ParseDuration)
+	RegisterStringGetterFor(time.Duration.String)
+
+	RegisterStringSetterFor(url.Parse)
+	RegisterStringGetterFor((*url.URL).String)
+	RegisterStringSetterFor(func(s string) (url.URL, error) {
+		u, err := url.Parse(s)
+		if err != nil {
+			return url.URL{}, err
+		}
+		return *u, nil
+	})
+	RegisterStringGetterFor(func(u url.URL) string { return u.String() })
+
+	RegisterStringSetterFor(regexp.Compile)
+	RegisterStringGetterFor((*regexp.Regexp).String)
+
+	RegisterStringSetterFor(parseCIDR)
+	RegisterStringGetterFor((*net.IPNet).String)
+	RegisterStringSetterFor(func(s string) (net.IPNet, error) {
+		n, err := parseCIDR(s)
+		if err != nil {
+			return net.IPNet{}, err
+		}
+		return *n, nil
+	})
+	RegisterStringGetterFor(func(n net.IPNet) string { return n.String() })
+
+	RegisterStringSetterFor(time.LoadLocation)
+	RegisterStringGetterFor((*time.Location).String)
+}
+
+func parseCIDR(s string) (*net.IPNet, error) {
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+// WithTimeLayouts specifies the layouts that are tried, in order,
+// when parsing a time.Time.  See time.Parse for the layout syntax.
+// The first layout is used when formatting with MakeStringGetter.
+// The default is time.RFC3339Nano which also accepts RFC3339 times
+// without fractional seconds.
+func WithTimeLayouts(layouts ...string) StringSetterArg {
+	return func(o *stringSetterOpts) {
+		o.timeLayouts = layouts
+	}
+}
+
+func makeTimeSetter(opts stringSetterOpts) (func(ctx context.Context, target reflect.Value, value string) error, error) {
+	if len(opts.timeLayouts) == 0 {
+		return nil, errors.Errorf("no time layouts provided")
+	}
+	return func(_ context.Context, target reflect.Value, value string) error {
+		var firstErr error
+		for _, layout := range opts.timeLayouts {
+			t, err := time.Parse(layout, value)
+			if err == nil {
+				target.Set(reflect.ValueOf(t))
+				return nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return errors.WithStack(firstErr)
+	}, nil
+}
+
+func makeTimeGetter(opts stringSetterOpts) (func(value reflect.Value) (string, error), error) {
+	if len(opts.timeLayouts) == 0 {
+		return nil, errors.Errorf("no time layouts provided")
+	}
+	return func(value reflect.Value) (string, error) {
+		return value.Interface().(time.Time).Format(opts.timeLayouts[0]), nil
+	}, nil
+}
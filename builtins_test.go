@@ -0,0 +1,102 @@
+package reflectutils_test
+
+import (
+	"math/big"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/muir/reflectutils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltinSetters(t *testing.T) {
+	type config struct {
+		URL      *url.URL       `default:"https://example.com/a?b=c"`
+		URLValue url.URL        `default:"http://localhost:8080"`
+		Regexp   *regexp.Regexp `default:"^a+b$"`
+		CIDR     *net.IPNet     `default:"10.1.0.0/16"`
+		IPNet    net.IPNet      `default:"fd00::/8"`
+		Prefix   netip.Prefix   `default:"192.168.0.0/24"`
+		Addr     netip.Addr     `default:"::1"`
+		IP       net.IP         `default:"10.0.0.1"`
+		Big      *big.Float     `default:"1.5e100"`
+		BigInt   *big.Int       `default:"123456789012345678901234567890"`
+		Location *time.Location `default:"America/New_York"`
+		Mode     os.FileMode    `default:"493"`
+		Time     time.Time      `default:"2021-02-03T04:05:06.5Z"`
+		TimeP    *time.Time     `default:"2021-02-03T04:05:06+02:00"`
+	}
+	var c config
+	require.NoError(t, reflectutils.FillInDefaultValues(&c))
+	assert.Equal(t, "example.com", c.URL.Host)
+	assert.Equal(t, "localhost:8080", c.URLValue.Host)
+	assert.True(t, c.Regexp.MatchString("aab"))
+	assert.Equal(t, "10.1.0.0/16", c.CIDR.String())
+	assert.Equal(t, "fd00::/8", c.IPNet.String())
+	assert.Equal(t, netip.MustParsePrefix("192.168.0.0/24"), c.Prefix)
+	assert.Equal(t, netip.MustParseAddr("::1"), c.Addr)
+	assert.Equal(t, "10.0.0.1", c.IP.String())
+	assert.Equal(t, "1.5e+100", c.Big.String())
+	assert.Equal(t, "123456789012345678901234567890", c.BigInt.String())
+	assert.Equal(t, "America/New_York", c.Location.String())
+	assert.Equal(t, os.FileMode(0o755), c.Mode, "decimal by default")
+	assert.True(t, time.Date(2021, 2, 3, 4, 5, 6, 5e8, time.UTC).Equal(c.Time))
+	assert.True(t, time.Date(2021, 2, 3, 2, 5, 6, 0, time.UTC).Equal(*c.TimeP))
+
+	v := reflect.ValueOf(c)
+	reflectutils.WalkStructElements(v.Type(), func(f reflect.StructField) bool {
+		t.Run("round-trip-"+f.Name, func(t *testing.T) {
+			get, err := reflectutils.MakeStringGetter(f.Type)
+			require.NoError(t, err)
+			s, err := get(v.FieldByIndex(f.Index))
+			require.NoError(t, err)
+			set, err := reflectutils.MakeStringSetter(f.Type)
+			require.NoError(t, err)
+			target := reflect.New(f.Type).Elem()
+			require.NoError(t, set(target, s))
+			s2, err := get(target)
+			require.NoError(t, err)
+			assert.Equal(t, s, s2)
+		})
+		return false
+	})
+}
+
+func TestFileModeOctal(t *testing.T) {
+	var model struct {
+		Mode os.FileMode `pt:"mode,base=8"`
+		Any  os.FileMode `pt:"any,base=0"`
+	}
+	require.NoError(t, reflectutils.Tag{Value: "mode=755,any=0o600"}.Fill(&model))
+	assert.Equal(t, os.FileMode(0o755), model.Mode)
+	assert.Equal(t, os.FileMode(0o600), model.Any)
+}
+
+func TestTimeLayouts(t *testing.T) {
+	var times []*time.Time
+	opts := []reflectutils.StringSetterArg{
+		reflectutils.WithSplitOn(";"),
+		reflectutils.WithTimeLayouts(time.DateOnly, time.RFC1123),
+	}
+	set, err := reflectutils.MakeStringSetter(reflect.TypeOf(times), opts...)
+	require.NoError(t, err)
+	require.NoError(t, set(reflect.ValueOf(&times).Elem(), "2022-03-04;Mon, 02 Jan 2006 15:04:05 UTC"))
+	require.Len(t, times, 2)
+	assert.Equal(t, "2022-03-04", times[0].Format(time.DateOnly))
+	assert.Equal(t, 2006, times[1].Year())
+	require.Error(t, set(reflect.ValueOf(&times).Elem(), "2022-03-04T00:00:00Z"), "RFC3339 not in the list")
+
+	get, err := reflectutils.MakeStringGetter(reflect.TypeOf(times), opts...)
+	require.NoError(t, err)
+	s, err := get(reflect.ValueOf(times))
+	require.NoError(t, err)
+	assert.Equal(t, "2022-03-04;2006-01-02", s)
+}
@@ -0,0 +1,135 @@
+package reflectutils
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"reflect"
+
+	"github.com/memsql/errors"
+)
+
+// ByteEncoding controls how []byte and [N]byte are converted to
+// and from strings.  See WithByteEncoding.
+type ByteEncoding int
+
+const (
+	// BytesList treats bytes like any other slice or array: the
+	// string is split and each element is parsed as a number.
+	// This is the default.
+	BytesList ByteEncoding = iota
+
+	// BytesString uses the string itself: "abc" is []byte("abc")
+	BytesString
+
+	// BytesHex uses hexadecimal encoding: "deadbeef"
+	BytesHex
+
+	// BytesBase64 uses base64.StdEncoding
+	BytesBase64
+
+	// BytesBase64URL uses base64.URLEncoding
+	BytesBase64URL
+
+	// BytesBase64Raw uses base64.RawStdEncoding (no padding)
+	BytesBase64Raw
+
+	// BytesBase64RawURL uses base64.RawURLEncoding (no padding)
+	BytesBase64RawURL
+)
+
+// WithByteEncoding specifies how []byte and [N]byte (and named types
+// based on them) are decoded from strings.  The default is
+// BytesList.  With any other encoding, the decoded value replaces the
+// existing slice regardless of SliceAppend and decoding into a [N]byte
+// is an error unless exactly N bytes are decoded.  Types that implement
+// encoding.TextUnmarshaler, like net.IP, are not affected.
+func WithByteEncoding(encoding ByteEncoding) StringSetterArg {
+	return func(o *stringSetterOpts) {
+		o.byteEncoding = encoding
+	}
+}
+
+var byteType = reflect.TypeOf(byte(0))
+
+var byteEncodings = map[string]ByteEncoding{
+	"list":         BytesList,
+	"string":       BytesString,
+	"hex":          BytesHex,
+	"base64":       BytesBase64,
+	"base64url":    BytesBase64URL,
+	"base64raw":    BytesBase64Raw,
+	"base64rawurl": BytesBase64RawURL,
+}
+
+func isByteSequence(t reflect.Type) bool {
+	return (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem() == byteType
+}
+
+type byteCodec struct {
+	decode func(string) ([]byte, error)
+	encode func([]byte) string
+}
+
+func base64Codec(enc *base64.Encoding) byteCodec {
+	return byteCodec{decode: enc.DecodeString, encode: enc.EncodeToString}
+}
+
+func getByteCodec(encoding ByteEncoding) (byteCodec, error) {
+	switch encoding {
+	case BytesString:
+		return byteCodec{
+			decode: func(s string) ([]byte, error) { return []byte(s), nil },
+			encode: func(b []byte) string { return string(b) },
+		}, nil
+	case BytesHex:
+		return byteCodec{decode: hex.DecodeString, encode: hex.EncodeToString}, nil
+	case BytesBase64:
+		return base64Codec(base64.StdEncoding), nil
+	case BytesBase64URL:
+		return base64Codec(base64.URLEncoding), nil
+	case BytesBase64Raw:
+		return base64Codec(base64.RawStdEncoding), nil
+	case BytesBase64RawURL:
+		return base64Codec(base64.RawURLEncoding), nil
+	default:
+		return byteCodec{}, errors.Errorf("unknown byte encoding %d", encoding)
+	}
+}
+
+func makeBytesSetter(t reflect.Type, opts stringSetterOpts) (func(ctx context.Context, target reflect.Value, value string) error, error) {
+	codec, err := getByteCodec(opts.byteEncoding)
+	if err != nil {
+		return nil, err
+	}
+	return func(_ context.Context, target reflect.Value, value string) error {
+		b, err := codec.decode(value)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if t.Kind() == reflect.Array {
+			if len(b) != t.Len() {
+				return errors.Errorf("decoded %d bytes but %s requires exactly %d", len(b), t, t.Len())
+			}
+			reflect.Copy(target, reflect.ValueOf(b))
+			return nil
+		}
+		target.Set(reflect.ValueOf(b).Convert(t))
+		return nil
+	}, nil
+}
+
+func makeBytesGetter(t reflect.Type, opts stringSetterOpts) (func(value reflect.Value) (string, error), error) {
+	codec, err := getByteCodec(opts.byteEncoding)
+	if err != nil {
+		return nil, err
+	}
+	return func(value reflect.Value) (string, error) {
+		if t.Kind() == reflect.Array {
+			b := make([]byte, t.Len())
+			reflect.Copy(reflect.ValueOf(b), value)
+			return codec.encode(b), nil
+		}
+		return codec.encode(value.Bytes()), nil
+	}, nil
+}
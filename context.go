@@ -0,0 +1,34 @@
+package reflectutils
+
+import (
+	"context"
+	"reflect"
+)
+
+type surroundingStructKey struct{}
+
+// SurroundingStruct returns the struct that holds the field that is
+// being set.  It is available to setters that take a context.Context
+// (see RegisterStringSetterContextFor) when they are called for struct
+// fields by Tag.Fill, FillInDefaultValues, or a struct setter made with
+// MakeStringSetterContext.  Fields that come earlier in the struct may
+// already have been set.  The struct must not be modified.
+func SurroundingStruct(ctx context.Context) (reflect.Value, bool) {
+	v, ok := ctx.Value(surroundingStructKey{}).(reflect.Value)
+	return v, ok
+}
+
+func withSurroundingStruct(ctx context.Context, v reflect.Value) context.Context {
+	return context.WithValue(ctx, surroundingStructKey{}, v)
+}
+
+// WithContext provides the context that Tag.Fill and
+// FillInDefaultValuesWithOptions pass to setters.  The default is
+// context.Background().  No more fields are set once the context is
+// done and the context's error is returned.  See
+// MakeStringSetterContext.
+func WithContext(ctx context.Context) FillOptArg {
+	return func(o *fillOpt) {
+		o.ctx = ctx
+	}
+}
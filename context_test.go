@@ -0,0 +1,132 @@
+package reflectutils_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/muir/reflectutils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Secret string
+
+type envKey struct{}
+
+func resolveSecret(ctx context.Context, s string) (Secret, error) {
+	switch {
+	case strings.HasPrefix(s, "env:"):
+		env, _ := ctx.Value(envKey{}).(map[string]string)
+		return Secret(env[strings.TrimPrefix(s, "env:")]), nil
+	case strings.HasPrefix(s, "file:"):
+		b, err := os.ReadFile(strings.TrimPrefix(s, "file:"))
+		return Secret(strings.TrimSpace(string(b))), err
+	default:
+		return Secret(s), nil
+	}
+}
+
+func TestStringSetterContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(path, []byte("hunter2\n"), 0o600))
+	ctx := context.WithValue(context.Background(), envKey{}, map[string]string{"TOKEN": "abc"})
+
+	r := reflectutils.NewSetterRegistry(reflectutils.DefaultSetterRegistry())
+	reflectutils.RegisterStringSetterContextForIn(r, resolveSecret)
+
+	var secrets []Secret
+	set, err := r.MakeStringSetterContext(reflect.TypeOf(secrets), reflectutils.WithSplitOn(" "))
+	require.NoError(t, err)
+	require.NoError(t, set(ctx, reflect.ValueOf(&secrets).Elem(), "env:TOKEN file:"+path+" plain"))
+	assert.Equal(t, []Secret{"abc", "hunter2", "plain"}, secrets)
+
+	err = set(ctx, reflect.ValueOf(&secrets).Elem(), "file:"+path+".missing")
+	var setErr *reflectutils.SetError
+	require.ErrorAs(t, err, &setErr)
+	assert.Equal(t, "[0]", setErr.Path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, set(canceled, reflect.ValueOf(&secrets).Elem(), "plain"), context.Canceled)
+
+	// without a context, setters see context.Background()
+	var s Secret
+	plain, err := r.MakeStringSetter(reflect.TypeOf(s))
+	require.NoError(t, err)
+	require.NoError(t, plain(reflect.ValueOf(&s).Elem(), "env:TOKEN"))
+	assert.Equal(t, Secret(""), s)
+
+	// RegisterStringSetter accepts functions that take a context too
+	r2 := reflectutils.NewSetterRegistry(nil)
+	r2.RegisterStringSetter(resolveSecret)
+	set, err = r2.MakeStringSetterContext(reflect.TypeOf(s))
+	require.NoError(t, err)
+	require.NoError(t, set(ctx, reflect.ValueOf(&s).Elem(), "env:TOKEN"))
+	assert.Equal(t, Secret("abc"), s)
+	assert.Panics(t, func() {
+		r2.RegisterStringSetter(func(int, string) (Secret, error) { return "", nil })
+	})
+}
+
+type Endpoint string
+
+func TestSurroundingStruct(t *testing.T) {
+	type service struct {
+		Host string   `pt:"host" default:"localhost"`
+		URL  Endpoint `pt:"url" default:"/health"`
+	}
+	reflectutils.RegisterStringSetterContextFor(func(ctx context.Context, s string) (Endpoint, error) {
+		if v, ok := reflectutils.SurroundingStruct(ctx); ok && strings.HasPrefix(s, "/") {
+			return Endpoint("http://" + v.FieldByName("Host").String() + s), nil
+		}
+		return Endpoint(s), nil
+	})
+	defer reflectutils.UnregisterStringSetterFor[Endpoint]()
+
+	var svc service
+	require.NoError(t, reflectutils.Tag{Value: "host=db,url=/ready"}.Fill(&svc, reflectutils.WithContext(context.Background())))
+	assert.Equal(t, Endpoint("http://db/ready"), svc.URL)
+
+	svc = service{}
+	require.NoError(t, reflectutils.FillInDefaultValues(&svc))
+	assert.Equal(t, Endpoint("http://localhost/health"), svc.URL)
+
+	var services []service
+	set, err := reflectutils.MakeStringSetterContext(reflect.TypeOf(services), reflectutils.WithSplitOn(" "))
+	require.NoError(t, err)
+	require.NoError(t, set(context.Background(), reflect.ValueOf(&services).Elem(), "host=a,url=/x host=b,url=/y"))
+	assert.Equal(t, []service{{Host: "a", URL: "http://a/x"}, {Host: "b", URL: "http://b/y"}}, services)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	var e Endpoint
+	set, err = reflectutils.MakeStringSetterContext(reflect.TypeOf(e))
+	require.NoError(t, err)
+	require.ErrorIs(t, set(canceled, reflect.ValueOf(&e).Elem(), "/x"), context.Canceled)
+}
+
+func TestFillCanceled(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	var model struct {
+		Host string `pt:"host" default:"localhost"`
+		Port int    `pt:"port" default:"80"`
+	}
+	err := reflectutils.Tag{Value: "host=db,port=5432"}.Fill(&model, reflectutils.WithContext(canceled))
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "", model.Host, "no fields are set")
+
+	err = reflectutils.FillInDefaultValuesWithOptions(&model, reflectutils.WithContext(canceled))
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, model.Port, "no fields are set")
+
+	set, err := reflectutils.MakeStringSetterContext(reflect.TypeOf(model))
+	require.NoError(t, err)
+	require.ErrorIs(t, set(canceled, reflect.ValueOf(&model).Elem(), "host=db"), context.Canceled)
+}
@@ -1,6 +1,7 @@
 package reflectutils
 
 import (
+	"context"
 	"reflect"
 
 	"github.com/memsql/errors"
@@ -17,9 +18,29 @@ import (
 // time.Duration; and any types preregistered with
 // RegisterStringSetter(); pointers to any of the above types.
 //
+// Values that cannot be set return a SetError whose Path starts
+// with the field name.
+//
 // The argument must be a pointer to a struct. Anything else will
 // return error. A nil pointer is not allowed.
 func FillInDefaultValues(pointerToStruct any) error {
+	return FillInDefaultValuesWithOptions(pointerToStruct)
+}
+
+// FillInDefaultValuesWithOptions is FillInDefaultValues with options.
+// Use CollectAllErrors to get an error for every field that cannot be
+// set.  Use WithTag to look for a tag other than "default".  Use
+// WithContext to provide the context passed to setters.  Use
+// WithSetterRegistry to consult a registry other than the default.
+func FillInDefaultValuesWithOptions(pointerToStruct any, opts ...FillOptArg) error {
+	opt := fillOpt{
+		tag:      "default",
+		ctx:      context.Background(),
+		registry: defaultRegistry,
+	}
+	for _, f := range opts {
+		f(&opt)
+	}
 	ptr := reflect.ValueOf(pointerToStruct)
 	if ptr.Kind() != reflect.Ptr {
 		return errors.Errorf("cannot fill in defaults for anything (%s) but a valid pointer", ptr.Kind())
@@ -31,9 +52,14 @@ func FillInDefaultValues(pointerToStruct any) error {
 	if valueType.Kind() != reflect.Struct {
 		return errors.Errorf("cannot fill in defaults for non-structs (%s)", valueType)
 	}
+	var errs []error
 	var firstError error
+	ctx := withSurroundingStruct(opt.ctx, ptr.Elem())
 	WalkStructElements(valueType, func(field reflect.StructField) bool {
-		tag, ok := LookupTag(field.Tag, "default")
+		if ctx.Err() != nil {
+			return false
+		}
+		tag, ok := LookupTag(field.Tag, opt.tag)
 		if !ok {
 			return true
 		}
@@ -44,16 +70,28 @@ func FillInDefaultValues(pointerToStruct any) error {
 		if !value.IsZero() {
 			return true
 		}
-		setter, err := MakeStringSetter(field.Type)
+		setter, err := MakeStringSetterContext(field.Type, WithRegistry(opt.registry))
 		if err != nil {
+			err = errors.Wrap(err, field.Name)
+			errs = append(errs, err)
 			firstError = err // override since this is worse
 			return true
 		}
-		err = setter(value, tag.Value)
-		if err != nil && firstError == nil {
-			firstError = err
+		err = setter(ctx, value, tag.Value)
+		if err != nil {
+			err = fieldError(err, field, opt.tag, tag.Value)
+			errs = append(errs, err)
+			if firstError == nil {
+				firstError = err
+			}
 		}
 		return true
 	})
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	if opt.collectErrors {
+		return errors.Join(errs...)
+	}
 	return firstError
 }
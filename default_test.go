@@ -1,6 +1,7 @@
 package reflectutils_test
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -45,3 +46,39 @@ func TestDefaultSetter(t *testing.T) {
 	require.Error(t, reflectutils.FillInDefaultValues(&BadDefault1{}))
 	require.Error(t, reflectutils.FillInDefaultValues(&BadDefault2{}))
 }
+
+func TestDefaultCollectAllErrors(t *testing.T) {
+	var bad struct {
+		A int           `default:"x"`
+		B int           `default:"1"`
+		C time.Duration `default:"soon"`
+		D []bool        `default:"t,maybe"`
+		E any           `default:"5"`
+	}
+	err := reflectutils.FillInDefaultValues(&bad)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "E: type interface {} not supported", "unsupported type overrides")
+
+	err = reflectutils.FillInDefaultValuesWithOptions(&bad, reflectutils.CollectAllErrors(true))
+	require.Error(t, err)
+	assert.Equal(t, 1, bad.B)
+	var paths []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var setErr *reflectutils.SetError
+		if errors.As(e, &setErr) {
+			assert.Equal(t, "default", setErr.Tag.Tag)
+			paths = append(paths, setErr.Path)
+		} else {
+			paths = append(paths, "other")
+		}
+	}
+	assert.Equal(t, []string{"A", "C", "D[1]", "other"}, paths)
+
+	var alt struct {
+		A int `init:"3"`
+		B int `default:"4"`
+	}
+	require.NoError(t, reflectutils.FillInDefaultValuesWithOptions(&alt, reflectutils.WithTag("init")))
+	assert.Equal(t, 3, alt.A)
+	assert.Equal(t, 0, alt.B)
+}
@@ -0,0 +1,309 @@
+package reflectutils
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/memsql/errors"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// DurationStyle controls how time.Duration values are parsed and
+// formatted.  See WithDurationStyle.
+type DurationStyle int
+
+const (
+	// DurationStandard uses time.ParseDuration and time.Duration.String.
+	// This is the default.
+	DurationStandard DurationStyle = iota
+
+	// DurationExtended parses with ParseExtendedDuration and formats
+	// with FormatExtendedDuration: "1w2d3h".
+	DurationExtended
+
+	// DurationISO8601 parses with ParseExtendedDuration and formats
+	// with FormatISO8601Duration: "P9DT3H".
+	DurationISO8601
+)
+
+// WithDurationStyle controls parsing and formatting of time.Duration.
+// The default, DurationStandard, uses the setter and getter registered
+// for time.Duration so it can be overridden with a SetterRegistry.  The
+// other styles take precedence over any registered setter.
+//
+// Only time.Duration itself is affected.  Named types based on it, like
+// "type TTL time.Duration", cannot be told apart from other int64 types
+// so they are set as integers.  Register a setter for them with
+// RegisterStringSetterFor and ParseExtendedDuration instead.
+func WithDurationStyle(style DurationStyle) StringSetterArg {
+	return func(o *stringSetterOpts) {
+		o.durationStyle = style
+	}
+}
+
+var extendedDurationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"µs": time.Microsecond, // U+00B5 micro sign
+	"μs": time.Microsecond, // U+03BC Greek small letter mu
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+// ParseExtendedDuration parses everything that time.ParseDuration
+// does and also accepts days ("d", 24 hours) and weeks ("w", 7 days)
+// so "7d", "2w", and "1d12h" are valid.  Days are always 24 hours
+// long: daylight saving time is not considered.
+//
+// ISO-8601 durations, like "P1DT2H" and "PT0.5S", are also accepted.
+// Weeks and days are supported but years and months are not because
+// they do not have a fixed length.  A leading minus sign is allowed.
+func ParseExtendedDuration(s string) (time.Duration, error) {
+	orig := s
+	var negative bool
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+	var d uint64
+	var err error
+	switch {
+	case s == "":
+		return 0, errors.Errorf("invalid duration '%s'", orig)
+	case s == "0":
+	case s[0] == 'P':
+		d, err = parseISO8601Duration(orig, s[1:])
+	default:
+		d, err = parseExtendedDuration(orig, s)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		// d is at most 1<<63 so this can be math.MinInt64
+		return time.Duration(-d), nil
+	}
+	if d > math.MaxInt64 {
+		return 0, errors.WithStack(&RangeError{Type: durationType, Value: orig})
+	}
+	return time.Duration(d), nil
+}
+
+// parseExtendedDuration returns the magnitude of the duration
+func parseExtendedDuration(orig, s string) (uint64, error) {
+	var total uint64
+	for s != "" {
+		i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+		if i <= 0 {
+			return 0, errors.Errorf("invalid duration '%s'", orig)
+		}
+		number := s[:i]
+		s = s[i:]
+		j := strings.IndexAny(s, "0123456789.")
+		if j == -1 {
+			j = len(s)
+		}
+		unit, ok := extendedDurationUnits[s[:j]]
+		if !ok {
+			return 0, errors.Errorf("unknown unit '%s' in duration '%s'", s[:j], orig)
+		}
+		s = s[j:]
+		var err error
+		total, err = addDurationPart(orig, total, number, unit)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// parseISO8601Duration returns the magnitude of the duration
+func parseISO8601Duration(orig, s string) (uint64, error) {
+	s0 := s
+	units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour}
+	var total uint64
+	var seenTime, seenPart bool
+	for s != "" {
+		if s[0] == 'T' {
+			if seenTime {
+				return 0, errors.Errorf("invalid duration '%s'", orig)
+			}
+			seenTime = true
+			units = map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+			s = s[1:]
+			continue
+		}
+		i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' && r != ',' })
+		if i <= 0 {
+			return 0, errors.Errorf("invalid duration '%s'", orig)
+		}
+		if !seenTime && (s[i] == 'Y' || s[i] == 'M') {
+			return 0, errors.Errorf("years and months are not supported in duration '%s'", orig)
+		}
+		unit, ok := units[s[i]]
+		if !ok {
+			return 0, errors.Errorf("invalid duration '%s'", orig)
+		}
+		delete(units, s[i]) // each unit at most once
+		var err error
+		total, err = addDurationPart(orig, total, strings.Replace(s[:i], ",", ".", 1), unit)
+		if err != nil {
+			return 0, err
+		}
+		seenPart = true
+		s = s[i+1:]
+	}
+	if !seenPart || strings.HasSuffix(s0, "T") {
+		return 0, errors.Errorf("invalid duration '%s'", orig)
+	}
+	return total, nil
+}
+
+// addDurationPart adds number*unit to total, rounding to the
+// nearest nanosecond.  The total may be as large as 1<<63 so that
+// the smallest negative duration can be represented.
+func addDurationPart(orig string, total uint64, number string, unit time.Duration) (uint64, error) {
+	r, ok := new(big.Rat).SetString(number)
+	if !ok || strings.ContainsAny(number, "/eE") {
+		return 0, errors.Errorf("invalid number '%s' in duration '%s'", number, orig)
+	}
+	r.Mul(r, new(big.Rat).SetInt64(int64(unit)))
+	// round half up: (2*num + denom) / (2*denom)
+	n := new(big.Int).Mul(r.Num(), big.NewInt(2))
+	n.Add(n, r.Denom())
+	n.Quo(n, new(big.Int).Mul(r.Denom(), big.NewInt(2)))
+	if !n.IsUint64() || n.Uint64() > 1<<63-total {
+		return 0, errors.WithStack(&RangeError{Type: durationType, Value: orig})
+	}
+	return total + n.Uint64(), nil
+}
+
+// FormatExtendedDuration formats a duration so that ParseExtendedDuration
+// can parse it.  Weeks and days are used when they are whole so 36 hours
+// formats as "1d12h" and 14 days formats as "2w".  Units that are zero are
+// omitted.  Anything less than a minute is formatted with time.Duration.String.
+func FormatExtendedDuration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+	var b strings.Builder
+	n := uint64(d)
+	if d < 0 {
+		b.WriteByte('-')
+		n = -n
+	}
+	for _, u := range []struct {
+		unit   time.Duration
+		suffix string
+	}{
+		{7 * 24 * time.Hour, "w"},
+		{24 * time.Hour, "d"},
+		{time.Hour, "h"},
+		{time.Minute, "m"},
+	} {
+		if c := n / uint64(u.unit); c > 0 {
+			b.WriteString(strconv.FormatUint(c, 10))
+			b.WriteString(u.suffix)
+			n %= uint64(u.unit)
+		}
+	}
+	if n > 0 {
+		b.WriteString(time.Duration(n).String())
+	}
+	return b.String()
+}
+
+// FormatISO8601Duration formats a duration as an ISO-8601 duration
+// using days, hours, minutes, and seconds: 36 hours formats as
+// "P1DT12H".  Fractional seconds are included when needed.  The zero
+// duration formats as "PT0S".
+func FormatISO8601Duration(d time.Duration) string {
+	if d == 0 {
+		return "PT0S"
+	}
+	var b strings.Builder
+	n := uint64(d)
+	if d < 0 {
+		b.WriteByte('-')
+		n = -n
+	}
+	b.WriteByte('P')
+	day := uint64(24 * time.Hour)
+	if n >= day {
+		b.WriteString(strconv.FormatUint(n/day, 10))
+		b.WriteByte('D')
+		n %= day
+	}
+	if n == 0 {
+		return b.String()
+	}
+	b.WriteByte('T')
+	for _, u := range []struct {
+		unit   time.Duration
+		suffix byte
+	}{
+		{time.Hour, 'H'},
+		{time.Minute, 'M'},
+	} {
+		if c := n / uint64(u.unit); c > 0 {
+			b.WriteString(strconv.FormatUint(c, 10))
+			b.WriteByte(u.suffix)
+			n %= uint64(u.unit)
+		}
+	}
+	if n > 0 {
+		b.WriteString(strconv.FormatUint(n/uint64(time.Second), 10))
+		if frac := n % uint64(time.Second); frac > 0 {
+			b.WriteByte('.')
+			b.WriteString(strings.TrimRight(strconv.FormatUint(frac+uint64(time.Second), 10)[1:], "0"))
+		}
+		b.WriteByte('S')
+	}
+	return b.String()
+}
+
+func checkDurationStyle(style DurationStyle) error {
+	switch style {
+	case DurationStandard, DurationExtended, DurationISO8601:
+		return nil
+	default:
+		return errors.Errorf("unknown duration style %d", style)
+	}
+}
+
+func makeDurationSetter(opts stringSetterOpts) (func(ctx context.Context, target reflect.Value, value string) error, error) {
+	if err := checkDurationStyle(opts.durationStyle); err != nil {
+		return nil, err
+	}
+	return func(_ context.Context, target reflect.Value, value string) error {
+		d, err := ParseExtendedDuration(value)
+		if err != nil {
+			return err
+		}
+		target.SetInt(int64(d))
+		return nil
+	}, nil
+}
+
+func makeDurationGetter(opts stringSetterOpts) (func(value reflect.Value) (string, error), error) {
+	if err := checkDurationStyle(opts.durationStyle); err != nil {
+		return nil, err
+	}
+	format := FormatExtendedDuration
+	if opts.durationStyle == DurationISO8601 {
+		format = FormatISO8601Duration
+	}
+	return func(value reflect.Value) (string, error) {
+		return format(time.Duration(value.Int())), nil
+	}, nil
+}
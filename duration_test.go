@@ -0,0 +1,129 @@
+package reflectutils_test
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/muir/reflectutils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExtendedDuration(t *testing.T) {
+	const day = 24 * time.Hour
+	cases := []struct {
+		value string
+		want  time.Duration
+		err   string
+	}{
+		{value: "7d", want: 7 * day},
+		{value: "2w", want: 14 * day},
+		{value: "1d12h", want: 36 * time.Hour},
+		{value: "1.5d", want: 36 * time.Hour},
+		{value: "-1w2d3h4m5.5s", want: -(9*day + 3*time.Hour + 4*time.Minute + 5500*time.Millisecond)},
+		{value: "300ms", want: 300 * time.Millisecond},
+		{value: "1µs2ns", want: 1002},
+		{value: "0", want: 0},
+		{value: "P1DT2H", want: 26 * time.Hour},
+		{value: "P2W", want: 14 * day},
+		{value: "PT0.5S", want: 500 * time.Millisecond},
+		{value: "PT1,5M", want: 90 * time.Second},
+		{value: "-P1D", want: -day},
+		{value: "", err: "invalid duration"},
+		{value: "7", err: "invalid duration"},
+		{value: "7y", err: "unknown unit"},
+		{value: "d", err: "invalid duration"},
+		{value: "1e3s", err: "unknown unit"},
+		{value: "P1Y", err: "years and months"},
+		{value: "P1M", err: "years and months"},
+		{value: "P", err: "invalid duration"},
+		{value: "P1DT", err: "invalid duration"},
+		{value: "PT1H2H", err: "invalid duration"},
+		{value: "P1H", err: "invalid duration"},
+		{value: "20000w", err: "out of range"},
+		{value: "-9223372036854775808ns", want: math.MinInt64},
+		{value: "9223372036854775807ns", want: math.MaxInt64},
+		{value: "9223372036854775808ns", err: "out of range"},
+		{value: "-9223372036854775809ns", err: "out of range"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			d, err := reflectutils.ParseExtendedDuration(tc.value)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, d)
+		})
+	}
+	var rangeErr *reflectutils.RangeError
+	_, err := reflectutils.ParseExtendedDuration("20000w")
+	require.ErrorAs(t, err, &rangeErr)
+	assert.ErrorIs(t, err, strconv.ErrRange)
+}
+
+func TestFormatExtendedDuration(t *testing.T) {
+	const day = 24 * time.Hour
+	cases := []struct {
+		d        time.Duration
+		extended string
+		iso      string
+	}{
+		{0, "0s", "PT0S"},
+		{36 * time.Hour, "1d12h", "P1DT12H"},
+		{14 * day, "2w", "P14D"},
+		{-(9*day + 90*time.Second), "-1w2d1m30s", "-P9DT1M30S"},
+		{1500 * time.Millisecond, "1.5s", "PT1.5S"},
+		{time.Hour + 3, "1h3ns", "PT1H0.000000003S"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.extended, func(t *testing.T) {
+			assert.Equal(t, tc.extended, reflectutils.FormatExtendedDuration(tc.d))
+			assert.Equal(t, tc.iso, reflectutils.FormatISO8601Duration(tc.d))
+			for _, s := range []string{tc.extended, tc.iso} {
+				d, err := reflectutils.ParseExtendedDuration(s)
+				require.NoError(t, err, s)
+				assert.Equal(t, tc.d, d, s)
+			}
+		})
+	}
+}
+
+func TestDurationStyle(t *testing.T) {
+	var ds []time.Duration
+	_, err := reflectutils.MakeStringSetter(reflect.TypeOf(ds), reflectutils.WithDurationStyle(reflectutils.DurationStyle(9)))
+	require.Error(t, err, "invalid style")
+
+	set, err := reflectutils.MakeStringSetter(reflect.TypeOf(ds))
+	require.NoError(t, err)
+	require.Error(t, set(reflect.ValueOf(&ds).Elem(), "7d"), "standard style is the default")
+
+	for style, want := range map[reflectutils.DurationStyle]string{
+		reflectutils.DurationExtended: "1w,1d12h",
+		reflectutils.DurationISO8601:  "P7D,P1DT12H",
+	} {
+		ds = nil
+		set, err := reflectutils.MakeStringSetter(reflect.TypeOf(ds), reflectutils.WithDurationStyle(style))
+		require.NoError(t, err)
+		require.NoError(t, set(reflect.ValueOf(&ds).Elem(), "7d,P1DT12H"))
+		get, err := reflectutils.MakeStringGetter(reflect.TypeOf(ds), reflectutils.WithDurationStyle(style))
+		require.NoError(t, err)
+		s, err := get(reflect.ValueOf(ds))
+		require.NoError(t, err)
+		assert.Equal(t, want, s)
+	}
+
+	var retention struct {
+		Keep *time.Duration `pt:"keep,duration=extended"`
+		TTL  time.Duration  `pt:"ttl,duration=iso8601"`
+	}
+	require.NoError(t, reflectutils.Tag{Value: "keep=30d,ttl=PT5M"}.Fill(&retention))
+	assert.Equal(t, 30*24*time.Hour, *retention.Keep)
+	assert.Equal(t, 5*time.Minute, retention.TTL)
+}
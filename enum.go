@@ -0,0 +1,187 @@
+package reflectutils
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/memsql/errors"
+)
+
+type integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+type EnumArg func(*enumOpts)
+
+type enumOpts struct {
+	caseInsensitive bool
+	numeric         bool
+	flags           bool
+}
+
+// EnumCaseInsensitive controls if enum names are matched without
+// regard to case.  The default is false.
+func EnumCaseInsensitive(b bool) EnumArg {
+	return func(o *enumOpts) {
+		o.caseInsensitive = b
+	}
+}
+
+// EnumAllowNumbers controls if an enum may also be set from a
+// number, like "2".  Numbers follow Go integer literal syntax so
+// "0x10" is accepted.  The number does not need to match one of
+// the names.  The default is false.
+func EnumAllowNumbers(b bool) EnumArg {
+	return func(o *enumOpts) {
+		o.numeric = b
+	}
+}
+
+// EnumFlags controls if the enum is a set of bit flags that can be
+// combined with "|": "read|write".  The empty string is zero.  The
+// default is false.
+func EnumFlags(b bool) EnumArg {
+	return func(o *enumOpts) {
+		o.flags = b
+	}
+}
+
+// RegisterEnum registers a setter and a getter for an integer type
+// with named values in the default registry.  This allows
+// MakeStringSetter, Tag.Fill and FillInDefaultValues to set the type
+// by name.
+//
+//	type Level int
+//
+//	const (
+//		Debug Level = iota
+//		Info
+//		Warn
+//	)
+//
+//	RegisterEnum(map[string]Level{"debug": Debug, "info": Info, "warn": Warn, "warning": Warn})
+//
+// When more than one name has the same value, MakeStringGetter uses the
+// one that sorts first.  Values that do not have a name are formatted as
+// numbers.
+func RegisterEnum[T integer](names map[string]T, opts ...EnumArg) {
+	RegisterEnumIn(defaultRegistry, names, opts...)
+}
+
+// RegisterEnumIn is RegisterEnum for a specific registry.
+func RegisterEnumIn[T integer](r *SetterRegistry, names map[string]T, opts ...EnumArg) {
+	var o enumOpts
+	for _, f := range opts {
+		f(&o)
+	}
+	e := newEnum(names, o)
+	RegisterStringSetterForIn(r, e.parse)
+	RegisterStringGetterForIn(r, e.format)
+}
+
+type enum[T integer] struct {
+	enumOpts
+	lookup map[string]T
+	names  map[T]string
+	sorted []string // sorted by value and then by name
+	values map[string]T
+}
+
+func newEnum[T integer](names map[string]T, o enumOpts) *enum[T] {
+	e := &enum[T]{
+		enumOpts: o,
+		lookup:   make(map[string]T),
+		names:    make(map[T]string),
+		values:   make(map[string]T, len(names)),
+	}
+	for name, value := range names {
+		e.values[name] = value
+		e.sorted = append(e.sorted, name)
+		if o.caseInsensitive {
+			e.lookup[strings.ToLower(name)] = value
+		} else {
+			e.lookup[name] = value
+		}
+	}
+	sort.Slice(e.sorted, func(i, j int) bool {
+		a, b := e.sorted[i], e.sorted[j]
+		if names[a] != names[b] {
+			return names[a] < names[b]
+		}
+		return a < b
+	})
+	for _, name := range e.sorted {
+		if _, ok := e.names[names[name]]; !ok {
+			e.names[names[name]] = name
+		}
+	}
+	return e
+}
+
+func (e *enum[T]) parse(s string) (T, error) {
+	if !e.flags {
+		return e.parseOne(s)
+	}
+	var v T
+	if s == "" {
+		return v, nil
+	}
+	for _, part := range strings.Split(s, "|") {
+		p, err := e.parseOne(strings.TrimSpace(part))
+		if err != nil {
+			return v, err
+		}
+		v |= p
+	}
+	return v, nil
+}
+
+func (e *enum[T]) parseOne(s string) (T, error) {
+	key := s
+	if e.caseInsensitive {
+		key = strings.ToLower(s)
+	}
+	if v, ok := e.lookup[key]; ok {
+		return v, nil
+	}
+	if e.numeric {
+		if i, err := strconv.ParseInt(s, 0, 64); err == nil && int64(T(i)) == i && (i >= 0 || T(i) < 0) {
+			return T(i), nil
+		}
+		if u, err := strconv.ParseUint(s, 0, 64); err == nil && uint64(T(u)) == u && T(u) >= 0 {
+			return T(u), nil
+		}
+	}
+	return 0, errors.Errorf("invalid value '%s' for %T, valid values are: %s", s, T(0), strings.Join(e.sorted, ", "))
+}
+
+func (e *enum[T]) format(v T) string {
+	if name, ok := e.names[v]; ok {
+		return name
+	}
+	if e.flags && v != 0 {
+		var parts []string
+		remaining := v
+		for _, name := range e.sorted {
+			f := e.values[name]
+			if f != 0 && v&f == f && remaining&f != 0 && e.names[f] == name {
+				parts = append(parts, name)
+				remaining &^= f
+			}
+		}
+		if remaining != 0 {
+			parts = append(parts, formatInteger(remaining))
+		}
+		return strings.Join(parts, "|")
+	}
+	return formatInteger(v)
+}
+
+func formatInteger[T integer](v T) string {
+	if v < 0 {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatUint(uint64(v), 10)
+}
@@ -0,0 +1,108 @@
+package reflectutils_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/muir/reflectutils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+)
+
+type Perm uint8
+
+const (
+	PermRead Perm = 1 << iota
+	PermWrite
+	PermExec
+)
+
+func TestRegisterEnum(t *testing.T) {
+	r := reflectutils.NewSetterRegistry(reflectutils.DefaultSetterRegistry())
+	reflectutils.RegisterEnumIn(r, map[string]Level{"debug": Debug, "info": Info, "warn": Warn, "warning": Warn},
+		reflectutils.EnumCaseInsensitive(true), reflectutils.EnumAllowNumbers(true))
+	reflectutils.RegisterEnumIn(r, map[string]Perm{"none": 0, "read": PermRead, "write": PermWrite, "exec": PermExec, "rw": PermRead | PermWrite},
+		reflectutils.EnumFlags(true))
+
+	cases := []struct {
+		target interface{}
+		value  string
+		want   interface{}
+		format string
+		err    string
+	}{
+		{target: new(Level), value: "info", want: Info, format: "info"},
+		{target: new(Level), value: "WARNING", want: Warn, format: "warn"},
+		{target: new(Level), value: "0", want: Debug, format: "debug"},
+		{target: new(Level), value: "7", want: Level(7), format: "7"},
+		{target: new(Level), value: "loud", err: "valid values are: debug, info, warn, warning"},
+		{target: new([]Level), value: "debug,Info", want: []Level{Debug, Info}, format: "debug,info"},
+		{target: new(Perm), value: "read|write", want: PermRead | PermWrite, format: "rw"},
+		{target: new(Perm), value: "read | exec", want: PermRead | PermExec, format: "read|exec"},
+		{target: new(Perm), value: "rw|exec", want: PermRead | PermWrite | PermExec, format: "read|write|exec"},
+		{target: new(Perm), value: "", want: Perm(0), format: "none"},
+		{target: new(Perm), value: "Read", err: "valid values are: none, read, write, rw, exec"},
+		{target: new(Perm), value: "1", err: "invalid value '1'"},
+	}
+	for _, tc := range cases {
+		v := reflect.ValueOf(tc.target).Elem()
+		t.Run(v.Type().String()+"-"+tc.value, func(t *testing.T) {
+			set, err := r.MakeStringSetter(v.Type())
+			require.NoError(t, err)
+			err = set(v, tc.value)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, v.Interface())
+			get, err := r.MakeStringGetter(v.Type())
+			require.NoError(t, err)
+			s, err := get(v)
+			require.NoError(t, err)
+			assert.Equal(t, tc.format, s)
+		})
+	}
+
+	var p Perm = PermExec | 1<<6
+	get, err := r.MakeStringGetter(reflect.TypeOf(p))
+	require.NoError(t, err)
+	s, err := get(reflect.ValueOf(p))
+	require.NoError(t, err)
+	assert.Equal(t, "exec|64", s, "unnamed bits")
+
+	// changing the map after registration has no effect
+	perms := map[string]Perm{"read": PermRead, "write": PermWrite}
+	reflectutils.RegisterEnumIn(r, perms, reflectutils.EnumFlags(true))
+	perms["read"] = PermExec
+	delete(perms, "write")
+	get, err = r.MakeStringGetter(reflect.TypeOf(p))
+	require.NoError(t, err)
+	s, err = get(reflect.ValueOf(PermRead | PermWrite))
+	require.NoError(t, err)
+	assert.Equal(t, "read|write", s)
+
+	reflectutils.RegisterEnum(map[string]Level{"debug": Debug, "info": Info, "warn": Warn})
+	defer reflectutils.UnregisterStringSetterFor[Level]()
+	defer reflectutils.UnregisterStringGetterFor[Level]()
+	var model struct {
+		Level   Level   `pt:"level" default:"warn"`
+		Verbose []Level `pt:"verbose,split=space"`
+	}
+	require.NoError(t, reflectutils.FillInDefaultValues(&model))
+	assert.Equal(t, Warn, model.Level)
+	require.NoError(t, reflectutils.Tag{Value: "level=debug,verbose=info warn"}.Fill(&model))
+	assert.Equal(t, Debug, model.Level)
+	assert.Equal(t, []Level{Info, Warn}, model.Verbose)
+	require.Error(t, reflectutils.Tag{Value: "level=2"}.Fill(&model), "numbers not allowed")
+}
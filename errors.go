@@ -0,0 +1,156 @@
+package reflectutils
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/memsql/errors"
+)
+
+// RangeError is returned by setters made with MakeStringSetter when a
+// value is a well-formed number but it does not fit in the target type.
+// For example, "300" cannot be stored in an int8.  RangeError wraps
+// strconv.ErrRange so errors.Is(err, strconv.ErrRange) is true.
+type RangeError struct {
+	Type  reflect.Type
+	Value string
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("value '%s' is out of range for %s", e.Value, e.Type)
+}
+
+func (e *RangeError) Unwrap() error { return strconv.ErrRange }
+
+// numberError converts strconv range errors into RangeError
+func numberError(t reflect.Type, value string, err error) error {
+	if errors.Is(err, strconv.ErrRange) {
+		return errors.WithStack(&RangeError{Type: t, Value: value})
+	}
+	return errors.WithStack(err)
+}
+
+// SetError is returned by setters made with MakeStringSetter, by Tag.Fill,
+// and by FillInDefaultValues when a string cannot be converted into a
+// value.  Type and Value describe the innermost value that failed: when
+// setting a []int from "1,x", Type is int and Value is "x".
+//
+// Path locates the failed value within the target.  Elements of arrays
+// and slices are "[i]" where i counts from zero within the input.  Map
+// entries are "[key]" using the key as written.  Struct fields are their
+// names.  For example: "Servers[1].Port".  Path is empty when the
+// target itself could not be set.
+//
+// Tag is set when the failed value came from a struct tag, as with
+// FillInDefaultValues, or was described by one, as with Tag.Fill.  It
+// is the tag of the innermost struct field.
+type SetError struct {
+	Type  reflect.Type
+	Value string
+	Path  string
+	Tag   Tag
+	Err   error
+}
+
+func (e *SetError) Error() string {
+	var prefix string
+	if e.Path != "" {
+		prefix = e.Path + ": "
+	}
+	if e.Tag.Tag != "" {
+		prefix = fmt.Sprintf("%s (%s:%q): ", e.Path, e.Tag.Tag, e.Tag.Value)
+	}
+	return fmt.Sprintf("%scannot set %s from '%s': %s", prefix, e.Type, e.Value, e.Err)
+}
+
+func (e *SetError) Unwrap() error { return e.Err }
+
+// setError adds path to the front of the path of each SetError in
+// err.  Errors that are not SetErrors become one for t and value.
+func setError(err error, t reflect.Type, value string, path string) error {
+	return updateSetErrors(err, t, value, func(e *SetError) {
+		e.Path = joinPath(path, e.Path)
+	})
+}
+
+// fieldError is setError for a struct field.  It also records
+// the field's tag.
+func fieldError(err error, f reflect.StructField, tagName string, value string) error {
+	return updateSetErrors(err, f.Type, value, func(e *SetError) {
+		e.Path = joinPath(f.Name, e.Path)
+		if e.Tag.Tag == "" {
+			if tag, ok := LookupTag(f.Tag, tagName); ok {
+				e.Tag = tag
+			}
+		}
+	})
+}
+
+// updateSetErrors applies update to a copy of the SetError in err.
+// SetErrors are never modified in place because the caller may still
+// hold them.  Errors combined with errors.Join are updated one by one.
+func updateSetErrors(err error, t reflect.Type, value string, update func(*SetError)) error {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		switch e := e.(type) {
+		case *SetError:
+			c := *e
+			update(&c)
+			return errors.WithStack(&c)
+		case interface{ Unwrap() []error }:
+			errs := e.Unwrap()
+			updated := make([]error, len(errs))
+			for i, err := range errs {
+				updated[i] = updateSetErrors(err, t, value, update)
+			}
+			return errors.Join(updated...)
+		}
+	}
+	c := SetError{Type: t, Value: value, Err: err}
+	update(&c)
+	return errors.WithStack(&c)
+}
+
+func joinPath(prefix, path string) string {
+	switch {
+	case path == "":
+		return prefix
+	case prefix == "" || strings.HasPrefix(path, "["):
+		return prefix + path
+	default:
+		return prefix + "." + path
+	}
+}
+
+// Reasons that ParseTag can reject a struct tag.  A TagParseError
+// wraps one of these so they can be matched with errors.Is.
+const (
+	ErrTagMissingKey        errors.String = "missing key"
+	ErrTagMissingColon      errors.String = "missing colon after key"
+	ErrTagUnquotedValue     errors.String = "unquoted value"
+	ErrTagUnterminatedQuote errors.String = "unterminated quote"
+	ErrTagBadEscape         errors.String = "bad escape"
+	ErrTagMissingSpace      errors.String = "missing space between key:value pairs"
+	ErrTagDuplicateKey      errors.String = "duplicate key"
+)
+
+// TagParseError is returned by ParseTag when a struct tag is not in
+// the conventional format.  Offset is the byte offset within StructTag
+// where the problem was found.  Key is the tag key being parsed, if
+// there was one.
+type TagParseError struct {
+	StructTag reflect.StructTag
+	Offset    int
+	Key       string
+	Reason    error
+}
+
+func (e *TagParseError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("invalid struct tag at offset %d (key %q): %s", e.Offset, e.Key, e.Reason)
+	}
+	return fmt.Sprintf("invalid struct tag at offset %d: %s", e.Offset, e.Reason)
+}
+
+func (e *TagParseError) Unwrap() error { return e.Reason }
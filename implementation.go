@@ -0,0 +1,162 @@
+package reflectutils
+
+import (
+	"context"
+	"encoding"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/memsql/errors"
+)
+
+type implementation struct {
+	name      string
+	typ       reflect.Type
+	construct func(args string) (reflect.Value, error)
+}
+
+// RegisterImplementation registers a named constructor for an interface
+// type, I, in the default registry.  With it, MakeStringSetter can set
+// fields of type I.  The string value selects the implementation by name
+// and may include arguments after a colon: "zstd:level=3" calls the
+// constructor registered as "zstd" with "level=3".  Without a colon,
+// the constructor is called with an empty string.
+//
+//	RegisterImplementation[Codec]("gzip", NewGzipCodec)
+//	RegisterImplementation[Codec]("zstd", NewZstdCodec)
+//
+// If name is empty, TypeName of T is used so that implementations from
+// different versions of the same package remain distinct.  T must
+// implement I and I must be an interface type or RegisterImplementation
+// will panic.  Registering the same name again replaces the constructor.
+//
+// MakeStringGetter formats implementations by name.  If the value
+// implements encoding.TextMarshaler, its text is used as the arguments.
+// If a type was registered under more than one name, the first one is
+// used.  Values whose type was not registered are formatted with TypeName.
+func RegisterImplementation[I any, T any](name string, ctor func(args string) (T, error)) {
+	RegisterImplementationIn[I](defaultRegistry, name, ctor)
+}
+
+// RegisterImplementationIn is RegisterImplementation for a specific registry.
+// Implementations registered in a parent registry are available in its
+// children.
+func RegisterImplementationIn[I any, T any](r *SetterRegistry, name string, ctor func(args string) (T, error)) {
+	iface, impl := typeOf[I](), typeOf[T]()
+	if iface.Kind() != reflect.Interface {
+		panic("call to RegisterImplementation with " + iface.String() + " which is not an interface")
+	}
+	if !impl.Implements(iface) {
+		panic("call to RegisterImplementation with " + impl.String() + " which does not implement " + iface.String())
+	}
+	if name == "" {
+		name = TypeName(impl)
+	}
+	r.registerImplementation(iface, implementation{
+		name: name,
+		typ:  impl,
+		construct: func(args string) (reflect.Value, error) {
+			v, err := ctor(args)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			return reflect.ValueOf(&v).Elem(), nil
+		},
+	})
+}
+
+func (r *SetterRegistry) registerImplementation(iface reflect.Type, impl implementation) {
+	r.lock.Lock()
+	if r.implementations == nil {
+		r.implementations = make(map[reflect.Type][]implementation)
+	}
+	// Readers iterate over the slice without holding the lock so
+	// it is replaced rather than modified.
+	old := r.implementations[iface]
+	impls := make([]implementation, 0, len(old)+1)
+	var replaced bool
+	for _, existing := range old {
+		if existing.name == impl.name {
+			existing = impl
+			replaced = true
+		}
+		impls = append(impls, existing)
+	}
+	if !replaced {
+		impls = append(impls, impl)
+	}
+	r.implementations[iface] = impls
+	r.lock.Unlock()
+
+	r.registerSetter(iface, func(_ context.Context, target reflect.Value, value string) error {
+		if value == "" {
+			target.Set(reflect.Zero(iface))
+			return nil
+		}
+		name, args, _ := strings.Cut(value, ":")
+		impl, ok := r.lookupImplementation(iface, func(i implementation) bool { return i.name == name })
+		if !ok {
+			return errors.Errorf("unknown implementation '%s' for %s, registered names are: %s",
+				name, iface, strings.Join(r.implementationNames(iface), ", "))
+		}
+		v, err := impl.construct(args)
+		if err != nil {
+			return errors.Wrapf(err, "construct %s", name)
+		}
+		if v.Kind() == reflect.Interface && v.IsNil() {
+			target.Set(reflect.Zero(iface))
+			return nil
+		}
+		target.Set(v.Convert(iface))
+		return nil
+	})
+	r.registerGetter(iface, func(value reflect.Value) string {
+		if value.IsNil() {
+			return ""
+		}
+		elem := value.Elem()
+		name := TypeName(elem.Type())
+		if impl, ok := r.lookupImplementation(iface, func(i implementation) bool { return i.typ == elem.Type() }); ok {
+			name = impl.name
+		}
+		if m, ok := elem.Interface().(encoding.TextMarshaler); ok {
+			if text, err := m.MarshalText(); err == nil && len(text) > 0 {
+				return name + ":" + string(text)
+			}
+		}
+		return name
+	})
+}
+
+// lookupImplementation searches this registry and then its parents
+func (r *SetterRegistry) lookupImplementation(iface reflect.Type, match func(implementation) bool) (implementation, bool) {
+	for c := r; c != nil; c = c.parent {
+		c.lock.RLock()
+		impls := c.implementations[iface]
+		c.lock.RUnlock()
+		for _, impl := range impls {
+			if match(impl) {
+				return impl, true
+			}
+		}
+	}
+	return implementation{}, false
+}
+
+func (r *SetterRegistry) implementationNames(iface reflect.Type) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for c := r; c != nil; c = c.parent {
+		c.lock.RLock()
+		for _, impl := range c.implementations[iface] {
+			if !seen[impl.name] {
+				seen[impl.name] = true
+				names = append(names, impl.name)
+			}
+		}
+		c.lock.RUnlock()
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,135 @@
+package reflectutils_test
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/muir/reflectutils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Codec interface {
+	Name() string
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return "gzip" }
+
+type zstdCodec struct {
+	Level int
+}
+
+func (z *zstdCodec) Name() string { return "zstd" }
+
+func (z *zstdCodec) MarshalText() ([]byte, error) {
+	if z.Level == 0 {
+		return nil, nil
+	}
+	return []byte("level=" + strconv.Itoa(z.Level)), nil
+}
+
+func newZstd(args string) (*zstdCodec, error) {
+	var z zstdCodec
+	if args == "" {
+		return &z, nil
+	}
+	level, ok := strings.CutPrefix(args, "level=")
+	if !ok {
+		return nil, errSyntax(args)
+	}
+	var err error
+	z.Level, err = strconv.Atoi(level)
+	return &z, err
+}
+
+type errSyntax string
+
+func (e errSyntax) Error() string { return "bad arguments: " + string(e) }
+
+func TestRegisterImplementation(t *testing.T) {
+	r := reflectutils.NewSetterRegistry(reflectutils.DefaultSetterRegistry())
+	reflectutils.RegisterImplementationIn[Codec](r, "gzip", func(string) (gzipCodec, error) { return gzipCodec{}, nil })
+	reflectutils.RegisterImplementationIn[Codec](r, "zstd", newZstd)
+	reflectutils.RegisterImplementationIn[Codec](r, "zst", newZstd)
+	// the child registers gzipCodec again under its TypeName which takes precedence when formatting
+	child := reflectutils.NewSetterRegistry(r)
+	reflectutils.RegisterImplementationIn[Codec](child, "", func(string) (gzipCodec, error) { return gzipCodec{}, nil })
+
+	cases := []struct {
+		value  string
+		want   Codec
+		format string
+		err    string
+	}{
+		{value: "gzip", want: gzipCodec{}, format: "reflectutils_test.gzipCodec"},
+		{value: "zstd", want: &zstdCodec{}, format: "zstd"},
+		{value: "zst:level=3", want: &zstdCodec{Level: 3}, format: "zstd:level=3"},
+		{value: "reflectutils_test.gzipCodec", want: gzipCodec{}, format: "reflectutils_test.gzipCodec"},
+		{value: "", want: nil, format: ""},
+		{value: "zstd:fast", err: "bad arguments: fast"},
+		{value: "lz4", err: "registered names are: gzip, reflectutils_test.gzipCodec, zst, zstd"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			var c Codec = gzipCodec{}
+			v := reflect.ValueOf(&c).Elem()
+			set, err := child.MakeStringSetter(v.Type())
+			require.NoError(t, err)
+			err = set(v, tc.value)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, c)
+			get, err := child.MakeStringGetter(v.Type())
+			require.NoError(t, err)
+			s, err := get(v)
+			require.NoError(t, err)
+			assert.Equal(t, tc.format, s)
+		})
+	}
+
+	var codecs []Codec
+	set, err := r.MakeStringSetter(reflect.TypeOf(codecs), reflectutils.WithSplitOn(" "))
+	require.NoError(t, err)
+	require.NoError(t, set(reflect.ValueOf(&codecs).Elem(), "gzip zstd:level=9"))
+	assert.Equal(t, []Codec{gzipCodec{}, &zstdCodec{Level: 9}}, codecs)
+	require.Error(t, set(reflect.ValueOf(&codecs).Elem(), "reflectutils_test.gzipCodec"), "only in the child")
+
+	assert.Panics(t, func() {
+		reflectutils.RegisterImplementationIn[Codec](r, "x", func(string) (zstdCodec, error) { return zstdCodec{}, nil })
+	}, "zstdCodec has a pointer receiver")
+	assert.Panics(t, func() {
+		reflectutils.RegisterImplementationIn[gzipCodec](r, "x", func(string) (gzipCodec, error) { return gzipCodec{}, nil })
+	}, "not an interface")
+}
+
+func TestRegisterImplementationConcurrency(t *testing.T) {
+	r := reflectutils.NewSetterRegistry(reflectutils.DefaultSetterRegistry())
+	reflectutils.RegisterImplementationIn[Codec](r, "zstd", newZstd)
+	var c Codec
+	set, err := r.MakeStringSetter(reflect.TypeOf(&c).Elem())
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			reflectutils.RegisterImplementationIn[Codec](r, "zstd", newZstd)
+		}()
+		go func() {
+			defer wg.Done()
+			var c Codec
+			assert.NoError(t, set(reflect.ValueOf(&c).Elem(), "zstd"))
+		}()
+	}
+	wg.Wait()
+}
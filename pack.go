@@ -0,0 +1,429 @@
+package reflectutils
+
+import (
+	"encoding"
+	"encoding/json"
+	"flag"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/memsql/errors"
+)
+
+var textMarshallerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// MakeStringGetter is the inverse of MakeStringSetter.  Based on type,
+// it returns a function that formats a reflect.Value as a string.  It is
+// assumed that the reflect.Type matches the reflect.Value.  If not, panic
+// is likely.
+//
+// The same StringSetterArgs that are given to MakeStringSetter should be
+// given to MakeStringGetter.  When they are, a value that is formatted by
+// the getter and then parsed by the setter will reproduce the original value.
+// Values that would not be reproduced are an error instead: with SplitPlain,
+// elements that contain the separator; map keys that contain the key/value
+// separator; and slices whose only element formats as an empty string.
+// Registered getters, TextMarshaler, and flag.Value are trusted to produce
+// strings that their setters parse back into the same value.
+//
+// Any type that matches a type registered with RegisterStringGetter will be
+// formatted with the corresponding function.  A string getter is pre-registered
+// for time.Duration and the other types that have pre-registered string setters.
+// time.Time is formatted with the first layout given by WithTimeLayouts.
+// Anything that implements encoding.TextMarshaler will be formatted that way.
+// Anything that implements flag.Value will be formatted with its String method.
+//
+// Nil pointers, nil slices, and nil maps format as an empty string.  Map entries
+// are sorted by their formatted key so that the output is stable.
+func MakeStringGetter(t reflect.Type, optArgs ...StringSetterArg) (func(value reflect.Value) (string, error), error) {
+	return makeStringGetter(t, makeStringSetterOpts(optArgs))
+}
+
+func makeStringGetter(t reflect.Type, opts stringSetterOpts) (func(value reflect.Value) (string, error), error) {
+	if opts.forceJSON {
+		return func(value reflect.Value) (string, error) {
+			enc, err := json.Marshal(value.Interface())
+			if err != nil {
+				return "", errors.WithStack(err)
+			}
+			return string(enc), nil
+		}, nil
+	}
+	if t == durationType && opts.durationStyle != DurationStandard {
+		return makeDurationGetter(opts)
+	}
+	if getter, ok := opts.registry.lookupGetter(t); ok {
+		return func(value reflect.Value) (string, error) {
+			value, ok := exported(value)
+			if !ok {
+				return "", errors.Errorf("cannot format %s that was obtained through an unexported field and is not addressable", t)
+			}
+			return getter(value), nil
+		}, nil
+	}
+	if t == timeType {
+		return makeTimeGetter(opts)
+	}
+	if t.Kind() == reflect.Ptr && t.Elem() == timeType {
+		return makePointerGetter(t, opts)
+	}
+	if t.AssignableTo(textMarshallerType) {
+		return func(value reflect.Value) (string, error) {
+			if t.Kind() == reflect.Ptr && value.IsNil() {
+				return "", nil
+			}
+			enc, err := value.Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return "", errors.WithStack(err)
+			}
+			return string(enc), nil
+		}, nil
+	}
+	if reflect.PtrTo(t).AssignableTo(textMarshallerType) {
+		return func(value reflect.Value) (string, error) {
+			enc, err := addressable(value).Addr().Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return "", errors.WithStack(err)
+			}
+			return string(enc), nil
+		}, nil
+	}
+	if t.AssignableTo(flagValueType) {
+		return func(value reflect.Value) (string, error) {
+			if t.Kind() == reflect.Ptr && value.IsNil() {
+				return "", nil
+			}
+			return value.Interface().(flag.Value).String(), nil
+		}, nil
+	}
+	if reflect.PtrTo(t).AssignableTo(flagValueType) {
+		return func(value reflect.Value) (string, error) {
+			return addressable(value).Addr().Interface().(flag.Value).String(), nil
+		}, nil
+	}
+	if opts.units != UnitsNone && isNumberKind(t.Kind()) {
+		return makeUnitsGetter(t, opts)
+	}
+	if opts.byteEncoding != BytesList && isByteSequence(t) {
+		return makeBytesGetter(t, opts)
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return makePointerGetter(t, opts)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		base := formatBase(opts.base)
+		return func(value reflect.Value) (string, error) {
+			return strconv.FormatInt(value.Int(), base), nil
+		}, nil
+	case reflect.Uint, reflect.Uintptr, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		base := formatBase(opts.base)
+		return func(value reflect.Value) (string, error) {
+			return strconv.FormatUint(value.Uint(), base), nil
+		}, nil
+	case reflect.Float32, reflect.Float64:
+		return func(value reflect.Value) (string, error) {
+			return strconv.FormatFloat(value.Float(), 'g', -1, t.Bits()), nil
+		}, nil
+	case reflect.String:
+		return func(value reflect.Value) (string, error) {
+			return value.String(), nil
+		}, nil
+	case reflect.Complex64, reflect.Complex128:
+		return func(value reflect.Value) (string, error) {
+			return strconv.FormatComplex(value.Complex(), 'g', -1, t.Bits()), nil
+		}, nil
+	case reflect.Bool:
+		return func(value reflect.Value) (string, error) {
+			return strconv.FormatBool(value.Bool()), nil
+		}, nil
+	case reflect.Array:
+		getElem, err := makeStringGetter(t.Elem(), opts.elementOpts())
+		if err != nil {
+			return nil, err
+		}
+		if opts.split == "" {
+			return func(value reflect.Value) (string, error) {
+				if value.Len() == 0 {
+					return "", nil
+				}
+				return getElem(value.Index(0))
+			}, nil
+		}
+		if err := opts.checkSplit(); err != nil {
+			return nil, err
+		}
+		return func(value reflect.Value) (string, error) {
+			return joinElements(value, getElem, opts)
+		}, nil
+	case reflect.Slice:
+		getElem, err := makeStringGetter(t.Elem(), opts.elementOpts())
+		if err != nil {
+			return nil, err
+		}
+		if err := opts.checkSplit(); err != nil {
+			return nil, err
+		}
+		return func(value reflect.Value) (string, error) {
+			s, err := joinElements(value, getElem, opts)
+			if err == nil && s == "" && value.Len() == 1 {
+				return "", errors.Errorf("cannot format %s with a single empty element because the empty string has no values", t)
+			}
+			return s, err
+		}, nil
+	case reflect.Map:
+		getKey, err := makeStringGetter(t.Key(), opts.mapElementOpts())
+		if err != nil {
+			return nil, err
+		}
+		getElem, err := makeStringGetter(t.Elem(), opts.mapElementOpts())
+		if err != nil {
+			return nil, err
+		}
+		if err := opts.checkSplit(); err != nil {
+			return nil, err
+		}
+		return func(value reflect.Value) (string, error) {
+			entries := make([]string, 0, value.Len())
+			iter := value.MapRange()
+			for iter.Next() {
+				k, err := getKey(iter.Key())
+				if err != nil {
+					return "", err
+				}
+				if strings.Contains(k, opts.kvSplit) {
+					return "", errors.Errorf("cannot format map key '%s' because it contains the key/value separator '%s'", k, opts.kvSplit)
+				}
+				v, err := getElem(iter.Value())
+				if err != nil {
+					return "", err
+				}
+				entries = append(entries, k+opts.kvSplit+v)
+			}
+			sort.Strings(entries)
+			return opts.joinValues(entries)
+		}, nil
+	case reflect.Struct:
+		if err := opts.checkSplit(); err != nil {
+			return nil, err
+		}
+		return func(value reflect.Value) (string, error) {
+			return formatStruct(value, opts)
+		}, nil
+	default:
+		return nil, errors.Errorf("type %s not supported", t)
+	}
+}
+
+func makePointerGetter(t reflect.Type, opts stringSetterOpts) (func(value reflect.Value) (string, error), error) {
+	getElem, err := makeStringGetter(t.Elem(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return func(value reflect.Value) (string, error) {
+		if value.IsNil() {
+			return "", nil
+		}
+		return getElem(value.Elem())
+	}, nil
+}
+
+// formatStruct is the inverse of the struct setter: it produces
+// key=value elements for the exported fields.  Positional fields
+// come first.  Fields that format as empty strings are skipped.
+// Entries of a "leftovers" map come last, sorted by key.  Struct
+// and map fields that are filled from a group of prefixed elements
+// are formatted the same way: "pool.max=10", "labels.team=infra".
+func formatStruct(value reflect.Value, opts stringSetterOpts) (string, error) {
+	elements, err := formatStructElements(value, opts)
+	if err != nil {
+		return "", err
+	}
+	return opts.joinValues(elements)
+}
+
+func formatStructElements(value reflect.Value, opts stringSetterOpts) ([]string, error) {
+	var positional []string
+	var named []string
+	var leftovers reflect.Value
+	var err error
+	WalkStructElements(value.Type(), func(f reflect.StructField) bool {
+		tag := f.Tag.Get("pt")
+		if err != nil || tag == "-" || !f.IsExported() {
+			return false
+		}
+		parts := strings.Split(tag, ",")
+		if key, ok := groupKey(f, parts, opts); ok {
+			fv := value.FieldByIndex(f.Index)
+			for fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					return false
+				}
+				fv = fv.Elem()
+			}
+			var sub []string
+			if fv.Kind() == reflect.Struct {
+				sub, err = formatStructElements(fv, opts)
+			} else {
+				var sso []StringSetterArg
+				sso, err = setterArgsFromParts(parts)
+				if err != nil {
+					err = errors.Wrap(err, f.Name)
+					return false
+				}
+				fieldOpts := opts.mapElementOpts()
+				for _, fn := range sso {
+					fn(&fieldOpts)
+				}
+				sub, err = formatMapGroup(fv, opts.kvSplit, fieldOpts.elementOpts())
+				if err != nil {
+					err = errors.Wrap(err, f.Name)
+				}
+			}
+			for _, s := range sub {
+				if s != "" {
+					named = append(named, key+"."+s)
+				}
+			}
+			return false
+		}
+		if f.Type.Kind() == reflect.Struct && !hasTextForm(f.Type, opts) {
+			// the fields of the struct are filled directly
+			return true
+		}
+		if hasPart(parts[1:], "leftovers") {
+			leftovers = value.FieldByIndex(f.Index)
+			return false
+		}
+		var sso []StringSetterArg
+		sso, err = setterArgsFromParts(parts)
+		if err != nil {
+			err = errors.Wrap(err, f.Name)
+			return false
+		}
+		fieldOpts := opts.mapElementOpts()
+		for _, fn := range sso {
+			fn(&fieldOpts)
+		}
+		var get func(reflect.Value) (string, error)
+		get, err = makeStringGetter(f.Type, fieldOpts)
+		if err != nil {
+			return false
+		}
+		var s string
+		s, err = get(value.FieldByIndex(f.Index))
+		if err != nil {
+			err = errors.Wrap(err, f.Name)
+			return false
+		}
+		if i, aErr := strconv.Atoi(parts[0]); aErr == nil {
+			for len(positional) <= i {
+				positional = append(positional, "")
+			}
+			positional[i] = s
+			return false
+		}
+		if s != "" {
+			named = append(named, fieldKey(f, parts)+opts.kvSplit+s)
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if leftovers.IsValid() && leftovers.Kind() == reflect.Map {
+		keys := leftovers.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			named = append(named, k.String()+opts.kvSplit+leftovers.MapIndex(k).String())
+		}
+	}
+	return append(positional, named...), nil
+}
+
+// formatMapGroup is the inverse of fillMapGroup: it formats each
+// entry of a map as "k=v", sorted.
+func formatMapGroup(value reflect.Value, kvSplit string, opts stringSetterOpts) ([]string, error) {
+	getKey, err := makeStringGetter(value.Type().Key(), opts)
+	if err != nil {
+		return nil, err
+	}
+	getElem, err := makeStringGetter(value.Type().Elem(), opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]string, 0, value.Len())
+	iter := value.MapRange()
+	for iter.Next() {
+		k, err := getKey(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(k, kvSplit) {
+			return nil, errors.Errorf("cannot format map key '%s' because it contains the key/value separator '%s'", k, kvSplit)
+		}
+		v, err := getElem(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, k+kvSplit+v)
+	}
+	sort.Strings(entries)
+	return entries, nil
+}
+
+// hasTextForm returns true if the type will be formatted by something other
+// than its kind.
+func hasTextForm(t reflect.Type, opts stringSetterOpts) bool {
+	if _, ok := opts.registry.lookupGetter(t); ok {
+		return true
+	}
+	return t.AssignableTo(textMarshallerType) || reflect.PtrTo(t).AssignableTo(textMarshallerType) ||
+		t.AssignableTo(flagValueType) || reflect.PtrTo(t).AssignableTo(flagValueType)
+}
+
+// formatBase returns the base to use when formatting integers so that
+// they can be parsed with the given base.
+func formatBase(base int) int {
+	if base < 2 || base > 36 {
+		return 10
+	}
+	return base
+}
+
+func joinElements(value reflect.Value, getElem func(reflect.Value) (string, error), opts stringSetterOpts) (string, error) {
+	values := make([]string, value.Len())
+	for i := range values {
+		s, err := getElem(value.Index(i))
+		if err != nil {
+			return "", err
+		}
+		values[i] = s
+	}
+	return opts.joinValues(values)
+}
+
+// exported returns a value that can be used with Interface and Call.
+// Values obtained through unexported fields cannot be, so if they are
+// addressable, a new value is made that points to the same memory.
+func exported(v reflect.Value) (reflect.Value, bool) {
+	if v.CanInterface() {
+		return v, true
+	}
+	if !v.CanAddr() {
+		return v, false
+	}
+	return reflect.NewAt(v.Type(), v.Addr().UnsafePointer()).Elem(), true
+}
+
+// addressable returns v if it can be addressed, otherwise it
+// returns an addressable copy of v.
+func addressable(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v
+	}
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+	return c
+}
@@ -0,0 +1,197 @@
+package reflectutils_test
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muir/reflectutils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringGetter(t *testing.T) {
+	i := 7
+	s := "pointed"
+	type tgType struct {
+		Int        int                `want:"-38"`
+		Int8       int8               `want:"-9"`
+		Uint16     uint16             `want:"3020"`
+		Uintptr    uintptr            `want:"12"`
+		Hex        uint32             `want:"1f" base:"16"`
+		Base0      int                `want:"-12" base:"0"`
+		Float32    float32            `want:"3.9"`
+		Float64    float64            `want:"4.32e+07"`
+		Complex64  complex64          `want:"(4+3i)"`
+		Complex128 complex128         `want:"(3.9+2.6i)"`
+		Bool       bool               `want:"true"`
+		String     string             `want:"foo"`
+		IntP       *int               `want:"7"`
+		StringP    *string            `want:"pointed"`
+		NilP       *int               `want:""`
+		IntSlice   []int              `want:"3,9,-10"`
+		NilInts    []int              `want:""`
+		NilStrings []string           `want:""`
+		NilMap     map[string]int     `want:""`
+		IntArray   [2]int             `want:"22,11"`
+		SS         []string           `want:"foo/bar" split:"/"`
+		SA         [2]string          `want:"foo,bar"`
+		Dur        time.Duration      `want:"30m0s"`
+		DurP       *time.Duration     `want:"15m0s"`
+		DurSlice   []time.Duration    `want:"15m0s,45m0s"`
+		Time       time.Time          `want:"2021-02-03T04:05:06Z"`
+		IP         net.IP             `want:"10.1.2.3"`
+		Map        map[string]int     `want:"a=1,b=2"`
+		MapKV      map[int][]int      `want:"1:2;3:4" split:";" kv:":"`
+		CSV        []string           `want:"\"x,y\",z" style:"csv"`
+		Escaped    []string           `want:"a\\,b,c\\\\" style:"escaped"`
+		Nested     [][]int            `want:"1,2;3" levels:";|,"`
+		NestedP    []*[2]string       `want:"a/b c/d" levels:" |/"`
+		Addr       Addr               `want:"host=db,port=5432,tls=true"`
+		Addrs      []Addr             `want:"host=a,port=0,tls=false,tags=x/y host=b,port=2,tls=false" levels:" |,|/"`
+		Bar        Bar                `want:"b/bar"`
+		J          *map[string]string `want:"{\"a\":\"b\"}" fj:"t"`
+		Bytes      uint64             `want:"64MiB" units:"bytes"`
+		SIBytes    int32              `want:"2MB" units:"bytes"`
+		Count      int                `want:"-2k" units:"si"`
+		Odd        uint16             `want:"1001" units:"si"`
+		Rate       float64            `want:"1.5G" units:"si"`
+		Tiny       float32            `want:"0.25B" units:"bytes"`
+		HexBytes   []byte             `want:"deadbeef" enc:"hex"`
+		Key        [2]byte            `want:"AQI=" enc:"base64"`
+		Raw        []byte             `want:"AQI" enc:"base64raw"`
+		Text       []byte             `want:"a,b" enc:"string"`
+	}
+	dur := 15 * time.Minute
+	tg := tgType{
+		Int:        -38,
+		Int8:       -9,
+		Uint16:     3020,
+		Uintptr:    12,
+		Hex:        31,
+		Base0:      -12,
+		Float32:    3.9,
+		Float64:    4.32e7,
+		Complex64:  4 + 3i,
+		Complex128: 3.9 + 2.6i,
+		Bool:       true,
+		String:     "foo",
+		IntP:       &i,
+		StringP:    &s,
+		IntSlice:   []int{3, 9, -10},
+		IntArray:   [2]int{22, 11},
+		SS:         []string{"foo", "bar"},
+		SA:         [2]string{"foo", "bar"},
+		Dur:        30 * time.Minute,
+		DurP:       &dur,
+		DurSlice:   []time.Duration{15 * time.Minute, 45 * time.Minute},
+		Time:       time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC),
+		IP:         net.ParseIP("10.1.2.3"),
+		Map:        map[string]int{"b": 2, "a": 1},
+		MapKV:      map[int][]int{3: {4}, 1: {2}},
+		CSV:        []string{"x,y", "z"},
+		Escaped:    []string{"a,b", `c\`},
+		Nested:     [][]int{{1, 2}, {3}},
+		NestedP:    []*[2]string{{"a", "b"}, {"c", "d"}},
+		Addr:       Addr{Host: "db", Port: 5432, TLS: true},
+		Addrs:      []Addr{{Host: "a", Tags: []string{"x", "y"}}, {Host: "b", Port: 2}},
+		Bar:        Bar("bar"),
+		J:          &map[string]string{"a": "b"},
+		Bytes:      64 << 20,
+		SIBytes:    2e6,
+		Count:      -2000,
+		Odd:        1001,
+		Rate:       1.5e9,
+		Tiny:       0.25,
+		HexBytes:   []byte{0xde, 0xad, 0xbe, 0xef},
+		Key:        [2]byte{1, 2},
+		Raw:        []byte{1, 2},
+		Text:       []byte("a,b"),
+	}
+	v := reflect.ValueOf(tg)
+	reflectutils.WalkStructElements(v.Type(), func(f reflect.StructField) bool {
+		t.Run(f.Name+"-"+f.Type.String(), func(t *testing.T) {
+			var opts []reflectutils.StringSetterArg
+			if split, ok := f.Tag.Lookup("split"); ok {
+				opts = append(opts, reflectutils.WithSplitOn(split))
+			}
+			if levels, ok := f.Tag.Lookup("levels"); ok {
+				split := strings.Split(levels, "|")
+				opts = append(opts, reflectutils.WithSplitOnLevels(split[0], split[1:]...))
+			}
+			if kv, ok := f.Tag.Lookup("kv"); ok {
+				opts = append(opts, reflectutils.WithKeyValueSplitOn(kv))
+			}
+			if base, ok := f.Tag.Lookup("base"); ok {
+				b, err := strconv.Atoi(base)
+				require.NoError(t, err, "parse base")
+				opts = append(opts, reflectutils.WithIntegerBase(b))
+			}
+			if style, ok := f.Tag.Lookup("style"); ok {
+				opts = append(opts, reflectutils.WithSplitStyle(splitStyles[style]))
+			}
+			if units, ok := f.Tag.Lookup("units"); ok {
+				opts = append(opts, reflectutils.WithUnits(unitNames[units]))
+			}
+			if enc, ok := f.Tag.Lookup("enc"); ok {
+				opts = append(opts, reflectutils.WithByteEncoding(byteEncodings[enc]))
+			}
+			if _, ok := f.Tag.Lookup("fj"); ok {
+				opts = append(opts, reflectutils.ForceJSON(true))
+			}
+			getter, err := reflectutils.MakeStringGetter(f.Type, opts...)
+			require.NoError(t, err, "make getter")
+			got, err := getter(v.FieldByIndex(f.Index))
+			require.NoError(t, err, "get")
+			assert.Equal(t, f.Tag.Get("want"), got, "formatted")
+
+			if f.Name == "Bar" || f.Name == "NilP" {
+				// flag.Value for Bar isn't symmetric and nil pointers don't round-trip
+				return
+			}
+			setter, err := reflectutils.MakeStringSetter(f.Type, opts...)
+			require.NoError(t, err, "make setter")
+			target := reflect.New(f.Type).Elem()
+			require.NoError(t, setter(target, got), "set")
+			assert.Equal(t, v.FieldByIndex(f.Index).Interface(), target.Interface(), "round trip")
+		})
+		return false // don't descend into time.Time
+	})
+}
+
+func TestStringGetterLossy(t *testing.T) {
+	cases := []struct {
+		name  string
+		value interface{}
+		opts  []reflectutils.StringSetterArg
+		err   string
+	}{
+		{name: "separator", value: []string{"a,b"}, err: "contains the separator ','"},
+		{name: "single empty", value: []string{""}, err: "single empty element"},
+		{name: "map key", value: map[string]string{"a=b": "c"}, err: "contains the key/value separator '='"},
+		{name: "no separator", value: []string{"a", "b"}, opts: []reflectutils.StringSetterArg{reflectutils.WithSplitOn("")}, err: "without a separator"},
+		{name: "struct", value: Addr{Host: "a,b"}, err: "contains the separator ','"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := reflect.ValueOf(tc.value)
+			get, err := reflectutils.MakeStringGetter(v.Type(), tc.opts...)
+			require.NoError(t, err)
+			_, err = get(v)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+
+	// a map value may contain the key/value separator
+	m := map[string]string{"a": "b=c"}
+	get, err := reflectutils.MakeStringGetter(reflect.TypeOf(m))
+	require.NoError(t, err)
+	s, err := get(reflect.ValueOf(m))
+	require.NoError(t, err)
+	assert.Equal(t, "a=b=c", s)
+}
@@ -1,10 +1,13 @@
 package reflectutils
 
 import (
+	"context"
+	"fmt"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/memsql/errors"
 )
@@ -36,7 +39,9 @@ type TagSet struct {
 // SplitTag breaks apart a reflect.StructTag into an array of annotated key/value pairs.
 // Tags are expected to be in the conventional format.  What does "contentional"
 // mean?  `name:"values,value=value" name2:"value"`.  See https://flaviocopes.com/go-tags/
-// a light introduction.
+// a light introduction.  Parsing stops at the first element that is not
+// in the conventional format.  Values are returned as written, without
+// removing escapes.  Use ParseTag to detect malformed tags.
 func SplitTag(tags reflect.StructTag) Tags {
 	found := make([]Tag, 0, 5)
 	s := string(tags)
@@ -56,6 +61,109 @@ func SplitTag(tags reflect.StructTag) Tags {
 	return found
 }
 
+// ParseTag is a strict version of SplitTag.  Where SplitTag stops
+// silently at the first element it cannot parse, ParseTag returns a
+// *TagParseError that says where and why.  Values are unquoted, like
+// reflect.StructTag.Get does, so `name:"a\"b"` has the value `a"b`.
+// Keys may not repeat.
+//
+// If there is an error, the tags that were parsed before the problem
+// are returned along with it.
+func ParseTag(tags reflect.StructTag) (Tags, error) {
+	s := string(tags)
+	fail := func(offset int, key string, reason error) error {
+		return errors.WithStack(&TagParseError{
+			StructTag: tags,
+			Offset:    offset,
+			Key:       key,
+			Reason:    reason,
+		})
+	}
+	var found Tags
+	seen := make(map[string]struct{})
+	i := 0
+	for {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if i == len(s) {
+			return found, nil
+		}
+		if len(found) > 0 && i > 0 && s[i-1] != ' ' {
+			return found, fail(i, "", ErrTagMissingSpace)
+		}
+
+		// key is the same as reflect.StructTag.Lookup: anything
+		// but space, quote, colon, and control characters
+		start := i
+		for i < len(s) && s[i] > ' ' && s[i] != ':' && s[i] != '"' && s[i] != 0x7f {
+			i++
+		}
+		key := s[start:i]
+		switch {
+		case key == "":
+			return found, fail(start, "", ErrTagMissingKey)
+		case i == len(s) || s[i] != ':':
+			return found, fail(i, key, ErrTagMissingColon)
+		}
+		i++
+		if i == len(s) || s[i] != '"' {
+			return found, fail(i, key, ErrTagUnquotedValue)
+		}
+
+		// find the closing quote
+		open := i
+		i++
+		for i < len(s) && s[i] != '"' {
+			switch s[i] {
+			case '\n':
+				return found, fail(open, key, ErrTagUnterminatedQuote)
+			case '\\':
+				i++
+			}
+			i++
+		}
+		if i >= len(s) {
+			return found, fail(open, key, ErrTagUnterminatedQuote)
+		}
+		i++
+
+		value, offset, err := unquoteTagValue(s[open+1 : i-1])
+		if err != nil {
+			return found, fail(open+1+offset, key, ErrTagBadEscape)
+		}
+		if _, ok := seen[key]; ok {
+			return found, fail(start, key, ErrTagDuplicateKey)
+		}
+		seen[key] = struct{}{}
+		found = append(found, mkTag(key, value))
+	}
+}
+
+// unquoteTagValue is strconv.Unquote for the inside of a double
+// quoted string.  On failure, it returns the offset of the bad escape.
+func unquoteTagValue(s string) (string, int, error) {
+	if !strings.ContainsRune(s, '\\') {
+		return s, 0, nil
+	}
+	var b strings.Builder
+	var buf [utf8.UTFMax]byte
+	for rest := s; rest != ""; {
+		c, multibyte, tail, err := strconv.UnquoteChar(rest, '"')
+		if err != nil {
+			return "", len(s) - len(rest), err
+		}
+		if c < utf8.RuneSelf || !multibyte {
+			b.WriteByte(byte(c))
+		} else {
+			n := utf8.EncodeRune(buf[:], c)
+			b.Write(buf[:n])
+		}
+		rest = tail
+	}
+	return b.String(), 0, nil
+}
+
 func mkTag(tag, value string) Tag {
 	return Tag{
 		Tag:   tag,
@@ -86,6 +194,110 @@ func (s TagSet) Lookup(tag string) (Tag, bool) {
 	return mkTag("", ""), false
 }
 
+// Set adds a tag to the set.  If there is already a tag with the same
+// name, its value is replaced and it keeps its position.  Otherwise the
+// tag is added at the end.  Set does not modify the Tags that the set
+// was created from.
+func (s *TagSet) Set(tag Tag) {
+	s.copyOnWrite()
+	if i, ok := s.index[tag.Tag]; ok {
+		s.Tags[i] = tag
+		return
+	}
+	s.index[tag.Tag] = len(s.Tags)
+	s.Tags = append(s.Tags, tag)
+}
+
+// Delete removes a tag from the set.  It returns false if the tag
+// was not present.
+func (s *TagSet) Delete(tag string) bool {
+	if _, ok := s.index[tag]; !ok {
+		return false
+	}
+	s.copyOnWrite()
+	tags := s.Tags[:0]
+	for _, t := range s.Tags {
+		if t.Tag != tag {
+			tags = append(tags, t)
+		}
+	}
+	*s = tags.Set()
+	return true
+}
+
+// Merge calls Set for each of the tags so tags that are already
+// present are replaced and the rest are added in order.
+func (s *TagSet) Merge(tags Tags) {
+	for _, tag := range tags {
+		s.Set(tag)
+	}
+}
+
+// copyOnWrite makes sure that changes to the set do not modify the
+// Tags slice or index that it may share with other copies.
+func (s *TagSet) copyOnWrite() {
+	s.Tags = append(Tags(nil), s.Tags...)
+	index := make(map[string]int, len(s.index))
+	for k, v := range s.index {
+		index[k] = v
+	}
+	s.index = index
+}
+
+// String formats the set as a struct tag.  See Tags.String.
+func (s TagSet) String() string {
+	return s.Tags.String()
+}
+
+// StructTag returns the set as a reflect.StructTag that can be used
+// with reflect.StructOf.
+func (s TagSet) StructTag() reflect.StructTag {
+	return s.Tags.StructTag()
+}
+
+// String formats a single tag element, quoting the value with
+// strconv.Quote so that reflect.StructTag.Get returns the value
+// unchanged: `json:"s,omitempty"`.
+//
+// SplitTag returns values as they appear in the struct tag, with their
+// escapes.  Use ParseTag, or Tags.Unquote on the result of SplitTag,
+// to get values that can be formatted.
+func (tag Tag) String() string {
+	return tag.Tag + ":" + strconv.Quote(tag.Value)
+}
+
+// Unquote returns a copy of tags from SplitTag with the escapes
+// removed from their values, as ParseTag does, so that they can
+// be formatted with String.  An invalid escape is an error that
+// wraps ErrTagBadEscape.
+func (t Tags) Unquote() (Tags, error) {
+	var unquoted Tags
+	for _, tag := range t {
+		value, _, err := unquoteTagValue(tag.Value)
+		if err != nil {
+			return nil, errors.Wrapf(ErrTagBadEscape, "key %q", tag.Tag)
+		}
+		unquoted = append(unquoted, mkTag(tag.Tag, value))
+	}
+	return unquoted, nil
+}
+
+// String formats the tags in the conventional struct tag format
+// separated by spaces: `json:"s,omitempty" xml:"s_thing"`.
+func (t Tags) String() string {
+	parts := make([]string, len(t))
+	for i, tag := range t {
+		parts[i] = tag.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+// StructTag returns the tags as a reflect.StructTag that can be used
+// with reflect.StructOf.
+func (t Tags) StructTag() reflect.StructTag {
+	return reflect.StructTag(t.String())
+}
+
 func GetTag(tags reflect.StructTag, tag string) Tag {
 	t, _ := LookupTag(tags, tag)
 	return t
@@ -118,7 +330,61 @@ func LookupTag(tags reflect.StructTag, tag string) (Tag, bool) {
 //
 // When filling an array value, the default character to split upon is
 // comma, but other values can be set with "split=X" to split on X.
-// Special values of X are "quote", "space", and "none"
+// Special values of X are "quote", "space", and "none".
+//
+// Two more special values of X fill arrays whose elements contain
+// commas: "csv" splits on comma according to encoding/csv quoting rules
+// (see SplitCSV) and "escaped" splits on comma but not on commas that
+// are preceded by a backslash (see SplitEscaped).  When the model has
+// such fields, the tag is split on commas that are not escaped with a
+// backslash, "\\" is a backslash, and those escapes are removed from
+// the values of the "csv" and "escaped" fields before they are split
+// again.  Values of other fields are used as written.  Since the tag
+// and the field are both split on comma, commas inside the elements of
+// an "escaped" field need two levels of escaping, so "csv" is usually
+// easier to write:
+//
+//	Hosts	[]string	`pt:"hosts,split=csv"`		// `hosts="a\,b"\,c` -> ["a,b", "c"]
+//	Paths	[]string	`pt:"paths,split=escaped"`	// `paths=a\\\,b\,c` -> ["a,b", "c"]
+//
+// Elements that are marked "required" must be present:
+//
+//	Name	string	`pt:"name,required"`
+//
+// Elements that are missing can be given a value with "default=X".
+// Values can be restricted with "enum=X|Y|Z".  For arrays and slices,
+// each value is checked.  The model tag is split on commas, so neither
+// can contain a comma.
+//
+//	Level	string		`pt:"level,default=info,enum=debug|info|warn"`
+//	Modes	[]string	`pt:"modes,split=space,enum=r|w|x"`
+//
+// Integers are parsed in base 10 by default.  Use "base=N" to
+// parse in base N.  "base=0" accepts Go integer literal syntax
+// so "0x1F", "0o755", and "1_000" all work.
+//
+// Numbers can have unit suffixes with "units=si" (10k, 1.5M, 4Gi) or
+// "units=bytes" (64MiB, 1.5GB, 512B).  See WithUnits.
+//
+//	Limit	int64	`pt:"limit,units=bytes"`
+//
+// Durations can be written with days and weeks ("7d", "1w2d") or in
+// ISO-8601 format ("P1DT2H") with "duration=extended" or "duration=iso8601".
+// The two differ in how durations are formatted.  See WithDurationStyle.
+//
+// Byte slices and arrays can be decoded with "encoding=X" where X is
+// one of "list" (the default), "string", "hex", "base64", "base64url",
+// "base64raw", or "base64rawurl".  See WithByteEncoding.
+//
+//	Key	[32]byte	`pt:"key,encoding=hex"`
+//
+// Arrays can require that the number of values exactly matches their
+// length with "strict" and slices can limit the number of values with
+// "min=N" and "max=N".  A slice with a min is an error when its element
+// is missing.  See StrictArrayLength and WithSliceLengthLimits.
+//
+//	Pair	[2]int		`pt:"pair,split=space,strict"`
+//	Hosts	[]string	`pt:"hosts,split=space,min=1,max=3"`
 //
 // For bool values (and *bool, etc) an antonym can be specified:
 //
@@ -126,9 +392,38 @@ func LookupTag(tags reflect.StructTag, tag string) (Tag, bool) {
 //
 // So, then "mybool" maps to true, "!mybool" maps to false,
 // "other" maps to false and "!other" maps to true.
+//
+// Struct fields are filled directly from the elements as if their
+// fields were part of the model.  Struct fields marked "group" are
+// filled instead from the elements that start with their name and a
+// dot.  Map fields marked "group" are filled the same way, one entry
+// per element.
+//
+//	type Pool struct {
+//		Max	int	`db:"max"`
+//		Idle	int	`db:"idle"`
+//	}
+//
+//	type DBTags struct {
+//		Name	string			`db:"0"`
+//		Pool	Pool			`db:"pool,group"`	// "pool.max=10,pool.idle=2"
+//		Labels	map[string]string	`db:"labels,group"`	// "labels.team=infra,labels.tier=1"
+//	}
+//
+// A map[string]string field marked "leftovers" collects the elements
+// that did not match any other field.  Elements without a value are
+// recorded as "t", or "f" if they start with "!", the same as for bools.
+//
+//	Rest	map[string]string	`pt:",leftovers"`
+//
+// Use RejectUnknownElements to make elements that do not match an error
+// instead.
 func (tag Tag) Fill(model interface{}, opts ...FillOptArg) error {
 	opt := fillOpt{
-		tag: "pt",
+		tag:      "pt",
+		kvSplit:  "=",
+		ctx:      context.Background(),
+		registry: defaultRegistry,
 	}
 	for _, f := range opts {
 		f(&opt)
@@ -137,51 +432,95 @@ func (tag Tag) Fill(model interface{}, opts ...FillOptArg) error {
 	if !v.IsValid() || v.IsNil() || v.Type().Kind() != reflect.Ptr || v.Type().Elem().Kind() != reflect.Struct {
 		return errors.Errorf("Fill target must be a pointer to a struct, not %T", model)
 	}
-	// Break apart the tag into a list of elements (split on ",") and
-	// key/values (kv) when the elements have values (split on "=").  If
-	// an element doesn't have a value from =, then it gets a value of
-	// "t" (true) unless the element name starts with "!" in which case,
-	// the "!" is discarded and the value is "f" (false)
+	var elements []string
+	if usesTagEscapes(v.Type().Elem(), opt.tag) {
+		opt.tagEscapes = true
+		elements = splitTagElements(tag.Value)
+	} else {
+		elements = strings.Split(tag.Value, ",")
+	}
+	return fillStruct(opt.ctx, v.Elem(), elements, opt, makeStringSetterOpts([]StringSetterArg{WithRegistry(opt.registry)}))
+}
+
+// fillStruct does the work for Tag.Fill and for setting structs
+// with MakeStringSetter.  The target must be an addressable struct.
+// Field setters are made from the base options adjusted by the
+// model's tags.
+func fillStruct(ctx context.Context, target reflect.Value, elements []string, opt fillOpt, base stringSetterOpts) error {
+	ctx = withSurroundingStruct(ctx, target)
+	// Break apart the elements into key/values (kv) when the elements
+	// have values (split on "=").  If an element doesn't have a value
+	// from =, then it gets a value of "t" (true) unless the element name
+	// starts with "!" in which case, the "!" is discarded and the value
+	// is "f" (false)
 	kv := make(map[string]string)
-	elements := strings.Split(tag.Value, ",")
 	for _, element := range elements {
-		if eq := strings.IndexByte(element, '='); eq != -1 {
-			kv[element[0:eq]] = element[eq+1:]
+		k, v := elementKeyValue(element, opt.kvSplit)
+		kv[k] = v
+	}
+	used := make(map[string]bool)
+	usedPositions := make(map[int]bool)
+	lookup := func(key string) (string, bool) {
+		v, ok := kv[key]
+		if ok {
+			used[key] = true
+		}
+		return v, ok
+	}
+	// Without collectErrors, the last error wins
+	var errs []error
+	fail := func(err error) {
+		if opt.collectErrors {
+			errs = append(errs, err)
 		} else {
-			if strings.HasPrefix(element, "!") {
-				kv[element[1:]] = "f"
-			} else {
-				kv[element] = "t"
-			}
+			errs = []error{err}
 		}
 	}
-	// Now walk over the input model that controls the parsing.
-	var count int
-	var walkErr error
-	WalkStructElements(v.Type(), func(f reflect.StructField) bool {
-		tag := f.Tag.Get(opt.tag)
-		if tag == "-" {
-			return false
+	var known []string
+	var leftovers reflect.Value
+	var prefixes []string
+	group := func(f reflect.StructField, parts []string) (string, []string, bool) {
+		key, ok := groupKey(f, parts, base)
+		if !ok {
+			return "", nil, false
+		}
+		return key, groupElements(elements, key+"."), true
+	}
+	fillField := func(f reflect.StructField, parts []string) bool {
+		if hasPart(parts[1:], "leftovers") {
+			if f.Type.Kind() != reflect.Map || f.Type.Key().Kind() != reflect.String || f.Type.Elem().Kind() != reflect.String {
+				fail(errors.Errorf("%s: leftovers must be a map[string]string, not %s", f.Name, f.Type))
+			} else if !leftovers.IsValid() {
+				leftovers = target.FieldByIndex(f.Index)
+			}
+			return true
 		}
-		count++
-		parts := strings.Split(tag, ",")
 		var value string
+		var found bool
 		isBool := NonPointer(f.Type).Kind() == reflect.Bool
 		if len(parts) > 0 && parts[0] != "" {
 			i, err := strconv.Atoi(parts[0])
 			if err == nil {
 				// positional!
-				if i >= len(elements) {
-					return true
+				if i < len(elements) {
+					value = elements[i]
+					found = true
+					usedPositions[i] = true
+					delete(kv, value) // exclude from rest match
 				}
-				value = elements[i]
-				delete(kv, value) // exclude from rest match
 			} else {
 				if isBool {
 					for _, p := range parts {
+						if p == "required" {
+							continue
+						}
+						if p != "" && !strings.Contains(p, "=") && p != "strict" {
+							known = append(known, strings.TrimPrefix(p, "!"))
+						}
 						if p != "" && p[0] == '!' {
-							if v, ok := kv[p[1:]]; ok {
+							if v, ok := lookup(p[1:]); ok {
 								value = v
+								found = true
 								switch value {
 								case "f":
 									value = "t"
@@ -189,59 +528,569 @@ func (tag Tag) Fill(model interface{}, opts ...FillOptArg) error {
 									value = "f"
 								}
 							}
-						} else if v, ok := kv[p]; ok {
+						} else if v, ok := lookup(p); ok {
 							value = v
+							found = true
 							break
 						}
 					}
 				} else {
-					value = kv[parts[0]]
+					known = append(known, parts[0])
+					value, found = lookup(parts[0])
 				}
 			}
 		} else {
-			value = kv[f.Name]
+			known = append(known, f.Name)
+			value, found = lookup(f.Name)
 		}
-		if value == "" {
+		if !found {
+			value, found = partValue(parts[1:], "default=")
+		}
+		required := hasPart(parts[1:], "required")
+		sso, err := setterArgsFromParts(parts)
+		if err != nil {
+			fail(errors.Wrap(err, f.Name))
 			return true
 		}
-		var sso []StringSetterArg
-		if len(parts) > 1 {
-			for _, part := range parts[1:] {
-				if strings.HasPrefix(part, "split=") {
-					splitOn := part[len("split="):]
-					switch splitOn {
-					case "quote":
-						splitOn = `"`
-					case "space":
-						splitOn = " "
-					}
-					sso = append(sso, WithSplitOn(splitOn))
-				}
+		if !found && required {
+			if _, g, _ := group(f, parts); len(g) > 0 {
+				return true
 			}
+			fail(errors.Errorf("%s is required", fieldKey(f, parts)))
+			return true
+		}
+		setterOpts := base
+		for _, f := range sso {
+			f(&setterOpts)
 		}
-		set, err := MakeStringSetter(f.Type, sso...)
+		// a missing slice is still checked against its min= by the setter
+		if value == "" && (setterOpts.sliceMin == 0 || NonPointer(f.Type).Kind() != reflect.Slice) {
+			return true
+		}
+		if opt.tagEscapes && (hasPart(parts[1:], "split=csv") || hasPart(parts[1:], "split=escaped")) {
+			value = unescapeTagElement(value)
+		}
+		if enum, ok := partValue(parts[1:], "enum="); ok && value != "" {
+			if err := checkEnum(f.Type, strings.Split(enum, "|"), value, setterOpts); err != nil {
+				fail(fieldError(err, f, opt.tag, value))
+				return true
+			}
+		}
+		set, err := makeStringSetter(f.Type, setterOpts)
 		if err != nil {
-			walkErr = errors.Wrapf(err, "Cannot set %s", f.Type)
+			fail(errors.Wrapf(err, "Cannot set %s", f.Type))
 			return true
 		}
-		err = set(v.Elem().FieldByIndex(f.Index), value)
+		err = set(ctx, target.FieldByIndex(f.Index), value)
 		if err != nil {
-			walkErr = errors.Wrap(err, f.Name)
+			fail(fieldError(err, f, opt.tag, value))
 		}
 		return true
+	}
+	// Now walk over the input model that controls the parsing.
+	WalkStructElements(target.Type(), func(f reflect.StructField) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		tag := f.Tag.Get(opt.tag)
+		if tag == "-" {
+			return false
+		}
+		if !f.IsExported() {
+			// the exported fields of embedded structs can be set
+			return f.Anonymous
+		}
+		parts := strings.Split(tag, ",")
+		descend := fillField(f, parts)
+		key, g, ok := group(f, parts)
+		if !ok {
+			return descend
+		}
+		prefixes = append(prefixes, key+".")
+		if len(g) == 0 {
+			return false
+		}
+		if NonPointer(f.Type).Kind() == reflect.Map {
+			sso, err := setterArgsFromParts(parts)
+			if err != nil {
+				return false // already reported
+			}
+			setterOpts := base
+			for _, f := range sso {
+				f(&setterOpts)
+			}
+			for _, err := range fillMapGroup(ctx, fieldTarget(target, f), g, opt.kvSplit, setterOpts.elementOpts()) {
+				fail(fieldError(err, f, opt.tag, ""))
+			}
+			return false
+		}
+		err := fillStruct(ctx, fieldTarget(target, f), g, opt, base)
+		if err == nil {
+			return false
+		}
+		subErrs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			subErrs = joined.Unwrap()
+		}
+		for _, err := range subErrs {
+			var setErr *SetError
+			if errors.As(err, &setErr) {
+				fail(setError(err, f.Type, "", f.Name))
+			} else {
+				fail(errors.Wrap(err, f.Name))
+			}
+		}
+		return false
+	})
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	if !opt.collectErrors && len(errs) > 0 {
+		return errs[0]
+	}
+	if !opt.rejectUnknown && !leftovers.IsValid() {
+		return errors.Join(errs...)
+	}
+	var unknown []string
+	for i, element := range elements {
+		if element == "" || usedPositions[i] {
+			continue
+		}
+		key, value := elementKeyValue(element, opt.kvSplit)
+		if used[key] || hasPrefix(key, prefixes) {
+			continue
+		}
+		if leftovers.IsValid() {
+			if leftovers.IsNil() {
+				leftovers.Set(reflect.MakeMap(leftovers.Type()))
+			}
+			leftovers.SetMapIndex(reflect.ValueOf(key).Convert(leftovers.Type().Key()),
+				reflect.ValueOf(value).Convert(leftovers.Type().Elem()))
+			continue
+		}
+		if suggestion, ok := suggestKey(key, known); ok {
+			unknown = append(unknown, fmt.Sprintf("'%s' (did you mean '%s'?)", key, suggestion))
+		} else {
+			unknown = append(unknown, "'"+key+"'")
+		}
+	}
+	if len(unknown) > 0 {
+		errs = append(errs, errors.Errorf("unknown element(s): %s", strings.Join(unknown, ", ")))
+	}
+	return errors.Join(errs...)
+}
+
+// groupKey returns the key for fields that are filled from a group
+// of elements that share a prefix: "pool.max=10,pool.idle=2".  Only
+// struct and map fields marked "group" are filled this way.  The key
+// is the name in the tag or, if there isn't one, the name of the field.
+func groupKey(f reflect.StructField, parts []string, opts stringSetterOpts) (string, bool) {
+	t := NonPointer(f.Type)
+	if hasTextForm(t, opts) {
+		return "", false
+	}
+	if _, ok := opts.registry.lookupSetter(t); ok {
+		return "", false
+	}
+	if len(parts) > 0 && parts[0] != "" {
+		if _, err := strconv.Atoi(parts[0]); err == nil {
+			return "", false
+		}
+	}
+	if len(parts) < 2 || !hasPart(parts[1:], "group") || hasPart(parts[1:], "leftovers") {
+		return "", false
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return fieldKey(f, parts), true
+	default:
+		return "", false
+	}
+}
+
+// groupElements returns the elements that start with prefix, with
+// the prefix removed.  A leading "!" is kept: "!pool.shared" becomes
+// "!shared".
+func groupElements(elements []string, prefix string) []string {
+	var group []string
+	for _, element := range elements {
+		if strings.HasPrefix(element, prefix) {
+			group = append(group, element[len(prefix):])
+		} else if strings.HasPrefix(element, "!"+prefix) {
+			group = append(group, "!"+element[len(prefix)+1:])
+		}
+	}
+	return group
+}
+
+func hasPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldTarget returns the field, allocating through pointers as needed
+func fieldTarget(target reflect.Value, f reflect.StructField) reflect.Value {
+	v := target.FieldByIndex(f.Index)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// fillMapGroup sets map entries from "key=value" elements
+func fillMapGroup(ctx context.Context, target reflect.Value, elements []string, kvSplit string, opts stringSetterOpts) []error {
+	t := target.Type()
+	setKey, err := makeStringSetter(t.Key(), opts)
+	if err != nil {
+		return []error{err}
+	}
+	setValue, err := makeStringSetter(t.Elem(), opts)
+	if err != nil {
+		return []error{err}
+	}
+	if target.IsNil() {
+		target.Set(reflect.MakeMap(t))
+	}
+	var errs []error
+	for _, element := range elements {
+		k, v := elementKeyValue(element, kvSplit)
+		key := reflect.New(t.Key()).Elem()
+		if err := setKey(ctx, key, k); err != nil {
+			errs = append(errs, setError(err, t.Key(), k, "["+k+"]"))
+			continue
+		}
+		value := reflect.New(t.Elem()).Elem()
+		if err := setValue(ctx, value, v); err != nil {
+			errs = append(errs, setError(err, t.Elem(), v, "["+k+"]"))
+			continue
+		}
+		target.SetMapIndex(key, value)
+	}
+	return errs
+}
+
+// elementKeyValue splits a tag element into a key and a value.  If
+// the element doesn't have a value, then it gets a value of "t"
+// (true) unless the element starts with "!" in which case, the "!" is
+// discarded and the value is "f" (false).
+func elementKeyValue(element string, kvSplit string) (string, string) {
+	if k, v, ok := strings.Cut(element, kvSplit); ok {
+		return k, v
+	}
+	if strings.HasPrefix(element, "!") {
+		return element[1:], "f"
+	}
+	return element, "t"
+}
+
+// partValue returns the rest of the first part that starts with prefix
+func partValue(parts []string, prefix string) (string, bool) {
+	for _, p := range parts {
+		if strings.HasPrefix(p, prefix) {
+			return p[len(prefix):], true
+		}
+	}
+	return "", false
+}
+
+// checkEnum verifies that value is one of the allowed values.  For
+// arrays and slices that are set element by element, each element
+// is checked.
+func checkEnum(t reflect.Type, allowed []string, value string, opts stringSetterOpts) error {
+	check := func(t reflect.Type, v string) error {
+		for _, a := range allowed {
+			if v == a {
+				return nil
+			}
+		}
+		return errors.WithStack(&SetError{
+			Type:  t,
+			Value: v,
+			Err:   errors.Errorf("'%s' is not one of: %s", v, strings.Join(allowed, ", ")),
+		})
+	}
+	t = NonPointer(t)
+	if (t.Kind() != reflect.Slice && t.Kind() != reflect.Array) || hasTextForm(t, opts) ||
+		(opts.byteEncoding != BytesList && isByteSequence(t)) {
+		return check(t, value)
+	}
+	values, err := opts.splitValue(value, -1)
+	if err != nil {
+		return err
+	}
+	for i, v := range values {
+		if err := check(t.Elem(), v); err != nil {
+			return setError(err, t.Elem(), v, "["+strconv.Itoa(i)+"]")
+		}
+	}
+	return nil
+}
+
+func hasPart(parts []string, part string) bool {
+	for _, p := range parts {
+		if p == part {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestKey finds the known key that is closest to key.  Only
+// keys that are a small number of edits away are suggested.
+func suggestKey(key string, known []string) (string, bool) {
+	maxDistance := len(key) / 2
+	if maxDistance > 3 {
+		maxDistance = 3
+	}
+	var best string
+	for _, k := range known {
+		if d := editDistance(key, k); d <= maxDistance {
+			best, maxDistance = k, d-1
+		}
+	}
+	return best, best != ""
+}
+
+// editDistance is the Levenshtein distance between a and b, counting
+// the transposition of two adjacent characters as a single edit so
+// that "nmae" is close to "name".
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && prev2[j-2]+1 < cur[j] {
+				cur[j] = prev2[j-2] + 1
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// setterArgsFromParts converts the directives in a model tag, like
+// "split=" and "base=", into StringSetterArgs.  The first part is
+// the name of the element and is skipped.
+func setterArgsFromParts(parts []string) ([]StringSetterArg, error) {
+	var sso []StringSetterArg
+	if len(parts) < 2 {
+		return nil, nil
+	}
+	var sliceMin, sliceMax int
+	var sliceLimits bool
+	for _, part := range parts[1:] {
+		if strings.HasPrefix(part, "split=") {
+			splitOn := part[len("split="):]
+			switch splitOn {
+			case "quote":
+				splitOn = `"`
+			case "space":
+				splitOn = " "
+			case "none":
+				splitOn = ""
+			case "csv":
+				splitOn = ","
+				sso = append(sso, WithSplitStyle(SplitCSV))
+			case "escaped":
+				splitOn = ","
+				sso = append(sso, WithSplitStyle(SplitEscaped))
+			}
+			sso = append(sso, WithSplitOn(splitOn))
+		}
+		if strings.HasPrefix(part, "base=") {
+			base, err := strconv.Atoi(part[len("base="):])
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid base")
+			}
+			sso = append(sso, WithIntegerBase(base))
+		}
+		if strings.HasPrefix(part, "units=") {
+			switch part[len("units="):] {
+			case "si":
+				sso = append(sso, WithUnits(UnitsSI))
+			case "bytes":
+				sso = append(sso, WithUnits(UnitsBytes))
+			case "none":
+				sso = append(sso, WithUnits(UnitsNone))
+			default:
+				return nil, errors.Errorf("invalid units '%s'", part[len("units="):])
+			}
+		}
+		if strings.HasPrefix(part, "duration=") {
+			switch part[len("duration="):] {
+			case "standard":
+				sso = append(sso, WithDurationStyle(DurationStandard))
+			case "extended":
+				sso = append(sso, WithDurationStyle(DurationExtended))
+			case "iso8601":
+				sso = append(sso, WithDurationStyle(DurationISO8601))
+			default:
+				return nil, errors.Errorf("invalid duration style '%s'", part[len("duration="):])
+			}
+		}
+		if part == "strict" {
+			sso = append(sso, StrictArrayLength(true))
+		}
+		if strings.HasPrefix(part, "min=") || strings.HasPrefix(part, "max=") {
+			n, err := strconv.Atoi(part[len("min="):])
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s", part[:3])
+			}
+			if part[:3] == "min" {
+				sliceMin = n
+			} else {
+				sliceMax = n
+			}
+			sliceLimits = true
+		}
+		if strings.HasPrefix(part, "encoding=") {
+			encoding, ok := byteEncodings[part[len("encoding="):]]
+			if !ok {
+				return nil, errors.Errorf("invalid byte encoding '%s'", part[len("encoding="):])
+			}
+			sso = append(sso, WithByteEncoding(encoding))
+		}
+	}
+	if sliceLimits {
+		sso = append(sso, WithSliceLengthLimits(sliceMin, sliceMax))
+	}
+	return sso, nil
+}
+
+// fieldKey returns the name used to match a field given the parts
+// of its model tag.
+func fieldKey(f reflect.StructField, parts []string) string {
+	if len(parts) > 0 && parts[0] != "" {
+		if _, err := strconv.Atoi(parts[0]); err == nil {
+			return "element " + parts[0]
+		}
+		return parts[0]
+	}
+	return f.Name
+}
+
+// splitTagElements splits a tag value on commas that are not
+// escaped with a backslash.  "\\" is an escaped backslash so the
+// comma in "a\\,b" separates elements.  The escapes are preserved.
+func splitTagElements(s string) []string {
+	var elements []string
+	var start int
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ',':
+			elements = append(elements, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(elements, s[start:])
+}
+
+// unescapeTagElement removes the escapes that splitTagElements
+// honors: "\\," becomes "," and "\\\\" becomes "\\".  Other
+// backslashes are kept.
+func unescapeTagElement(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == ',' || s[i+1] == '\\') {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// usesTagEscapes reports if any field of the model, t, has a
+// "split=csv" or "split=escaped" directive.
+func usesTagEscapes(t reflect.Type, tagName string) bool {
+	return doUsesTagEscapes(t, tagName, make(map[reflect.Type]bool))
+}
+
+func doUsesTagEscapes(t reflect.Type, tagName string, seen map[reflect.Type]bool) bool {
+	seen[t] = true
+	var found bool
+	WalkStructElements(t, func(f reflect.StructField) bool {
+		parts := strings.Split(f.Tag.Get(tagName), ",")
+		if hasPart(parts[1:], "split=csv") || hasPart(parts[1:], "split=escaped") {
+			found = true
+		}
+		if ft := NonPointer(f.Type); f.Type.Kind() == reflect.Ptr && ft.Kind() == reflect.Struct && !seen[ft] {
+			found = found || doUsesTagEscapes(ft, tagName, seen)
+		}
+		return !found
 	})
-	return walkErr
+	return found
 }
 
 type FillOptArg func(*fillOpt)
 
 type fillOpt struct {
-	tag string
+	tag           string
+	kvSplit       string
+	rejectUnknown bool
+	collectErrors bool
+	tagEscapes    bool
+	ctx           context.Context
+	registry      *SetterRegistry
 }
 
-// WithTag overrides the tag used by Tag.Fill.  The default is "pt".
+// WithTag overrides the tag used by Tag.Fill and
+// FillInDefaultValuesWithOptions.  The default is "pt" for Tag.Fill
+// and "default" for FillInDefaultValuesWithOptions.
 func WithTag(tag string) FillOptArg {
 	return func(o *fillOpt) {
 		o.tag = tag
 	}
 }
+
+// RejectUnknownElements controls what Tag.Fill does with elements of
+// the tag that do not match any field of the model.  If true, they are
+// an error that lists each of them along with a suggestion when one of
+// the model's keys is similar: "unknown element(s): 'nmae' (did you
+// mean 'name'?)".  The default is false: unknown elements are ignored.
+// Elements captured by a "leftovers" field are not unknown.
+func RejectUnknownElements(b bool) FillOptArg {
+	return func(o *fillOpt) {
+		o.rejectUnknown = b
+	}
+}
+
+// CollectAllErrors controls what happens when more than one field
+// cannot be set by Tag.Fill or FillInDefaultValuesWithOptions.  If
+// true, every field is attempted and all of the errors are returned
+// together, combined with errors.Join.  Use errors.As to find each
+// SetError in the result.  If false (the default), Tag.Fill returns
+// the error for the last field that failed and
+// FillInDefaultValuesWithOptions returns the first.
+func CollectAllErrors(b bool) FillOptArg {
+	return func(o *fillOpt) {
+		o.collectErrors = b
+	}
+}
@@ -2,12 +2,15 @@ package reflectutils_test
 
 import (
 	"encoding/json"
+	"errors"
 	"reflect"
+	"strconv"
 	"testing"
 
 	"github.com/muir/reflectutils"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSplitTag(t *testing.T) {
@@ -31,6 +34,139 @@ func ts(t *testing.T, tag reflect.StructTag, want ...string) {
 	assert.Equal(t, want, s, tag)
 }
 
+func TestParseTag(t *testing.T) {
+	cases := []struct {
+		tag    reflect.StructTag
+		want   reflectutils.Tags
+		reason error
+		offset int
+		key    string
+	}{
+		{
+			tag:  `env:"YO"  flag:"foo,bar"`,
+			want: reflectutils.Tags{{Tag: "env", Value: "YO"}, {Tag: "flag", Value: "foo,bar"}},
+		},
+		{
+			tag:  `a:"say \"hi\"" b:"C:\\dir" c:"\u00e9\x41\t"`,
+			want: reflectutils.Tags{{Tag: "a", Value: `say "hi"`}, {Tag: "b", Value: `C:\dir`}, {Tag: "c", Value: "\u00e9A\t"}},
+		},
+		{tag: ``},
+		{
+			tag:    `json:"a" xml:b`,
+			want:   reflectutils.Tags{{Tag: "json", Value: "a"}},
+			reason: reflectutils.ErrTagUnquotedValue,
+			offset: 13,
+			key:    "xml",
+		},
+		{tag: `json:`, reason: reflectutils.ErrTagUnquotedValue, offset: 5, key: "json"},
+		{tag: `json:"a`, reason: reflectutils.ErrTagUnterminatedQuote, offset: 5, key: "json"},
+		{tag: `json:"a\"`, reason: reflectutils.ErrTagUnterminatedQuote, offset: 5, key: "json"},
+		{tag: `a:"x" b:"1\q2"`, want: reflectutils.Tags{{Tag: "a", Value: "x"}}, reason: reflectutils.ErrTagBadEscape, offset: 10, key: "b"},
+		{tag: `a:"x" a:"y"`, want: reflectutils.Tags{{Tag: "a", Value: "x"}}, reason: reflectutils.ErrTagDuplicateKey, offset: 6, key: "a"},
+		{tag: `a:"x"b:"y"`, want: reflectutils.Tags{{Tag: "a", Value: "x"}}, reason: reflectutils.ErrTagMissingSpace, offset: 5},
+		{tag: `json`, reason: reflectutils.ErrTagMissingColon, offset: 4, key: "json"},
+		{tag: `:"x"`, reason: reflectutils.ErrTagMissingKey, offset: 0},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(string(tc.tag), func(t *testing.T) {
+			got, err := reflectutils.ParseTag(tc.tag)
+			assert.Equal(t, tc.want, got)
+			if tc.reason == nil {
+				require.NoError(t, err)
+				for _, tag := range got {
+					assert.Equal(t, tc.tag.Get(tag.Tag), tag.Value, "same as reflect")
+				}
+				return
+			}
+			require.Error(t, err)
+			t.Log(err)
+			assert.True(t, errors.Is(err, tc.reason), "reason")
+			var parseErr *reflectutils.TagParseError
+			require.True(t, errors.As(err, &parseErr))
+			assert.Equal(t, tc.offset, parseErr.Offset, "offset")
+			assert.Equal(t, tc.key, parseErr.Key, "key")
+		})
+	}
+}
+
+func TestTagString(t *testing.T) {
+	for _, tag := range []reflect.StructTag{
+		`env:"YO" flag:"foo,bar"`,
+		`json:"a,omitempty" pt:"x=1,y"`,
+		`json:"say \"hi\"" path:"C:\\dir" nl:"a\nb"`,
+		`pt:"x=a\\,b,y"`,
+		``,
+	} {
+		parsed, err := reflectutils.ParseTag(tag)
+		require.NoError(t, err)
+		assert.Equal(t, string(tag), parsed.String(), "ParseTag")
+		unquoted, err := reflectutils.SplitTag(tag).Unquote()
+		require.NoError(t, err)
+		assert.Equal(t, string(tag), unquoted.String(), "SplitTag")
+		assert.Equal(t, parsed, unquoted)
+	}
+	for _, tag := range []reflect.StructTag{
+		`p:"C:\\new"`,
+		`re:"a\\\\b"`,
+	} {
+		parsed, err := reflectutils.ParseTag(tag)
+		require.NoError(t, err)
+		assert.Equal(t, string(tag), parsed.String())
+		assert.Equal(t, tag.Get(parsed[0].Tag), parsed.StructTag().Get(parsed[0].Tag))
+	}
+	_, err := reflectutils.Tags{{Tag: "x", Value: `bad\q`}}.Unquote()
+	assert.ErrorIs(t, err, reflectutils.ErrTagBadEscape)
+	tags := reflectutils.Tags{
+		{Tag: "json", Value: `say "hi"`},
+		{Tag: "path", Value: `C:\dir`},
+		{Tag: "nl", Value: "a\nb"},
+	}
+	st := tags.StructTag()
+	assert.Equal(t, `json:"say \"hi\"" path:"C:\\dir" nl:"a\nb"`, string(st))
+	for _, tag := range tags {
+		assert.Equal(t, tag.Value, st.Get(tag.Tag), tag.Tag)
+	}
+}
+
+func TestTagSetMutation(t *testing.T) {
+	orig := reflectutils.SplitTag(`json:"a" xml:"b" env:"C"`)
+	set := orig.Set()
+	set.Set(reflectutils.Tag{Tag: "json", Value: "a,omitempty"})
+	set.Set(reflectutils.Tag{Tag: "yaml", Value: "a"})
+	assert.Equal(t, `json:"a,omitempty" xml:"b" env:"C" yaml:"a"`, set.String())
+	assert.Equal(t, `json:"a" xml:"b" env:"C"`, orig.String(), "original unchanged")
+
+	cp := set
+	assert.True(t, cp.Delete("xml"))
+	assert.False(t, cp.Delete("xml"))
+	assert.Equal(t, `json:"a,omitempty" env:"C" yaml:"a"`, cp.String())
+	assert.Equal(t, "b", set.Get("xml").Value, "copies are independent")
+	assert.Equal(t, "a", cp.Get("yaml").Value, "index is rebuilt")
+
+	cp.Merge(reflectutils.Tags{{Tag: "env", Value: "D"}, {Tag: "db", Value: "x"}})
+	assert.Equal(t, `json:"a,omitempty" env:"D" yaml:"a" db:"x"`, string(cp.StructTag()))
+
+	var empty reflectutils.TagSet
+	empty.Set(reflectutils.Tag{Tag: "a", Value: "b"})
+	assert.Equal(t, `a:"b"`, empty.String())
+
+	// add omitempty to every field of a generated struct
+	fields := []reflect.StructField{
+		{Name: "A", Type: reflect.TypeOf(""), Tag: `json:"a"`},
+		{Name: "B", Type: reflect.TypeOf(0), Tag: `json:"b" db:"b"`},
+	}
+	for i, f := range fields {
+		set := reflectutils.SplitTag(f.Tag).Set()
+		set.Set(reflectutils.Tag{Tag: "json", Value: set.Get("json").Value + ",omitempty"})
+		fields[i].Tag = set.StructTag()
+	}
+	b, err := json.Marshal(reflect.New(reflect.StructOf(fields)).Interface())
+	require.NoError(t, err)
+	assert.Equal(t, `{}`, string(b))
+	assert.Equal(t, reflect.StructTag(`json:"b,omitempty" db:"b"`), fields[1].Tag)
+}
+
 func TestTagGet(t *testing.T) {
 	tg(t, `env:"YO"  flag:"foo,bar"`, "env", "YO")
 }
@@ -73,6 +209,18 @@ func TestFill(t *testing.T) {
 			targetTag: "xyz",
 			metaTag:   "pf",
 		},
+		{
+			tests: struct {
+				T6 string `xyz:"mode=0o755,mask=0xff" want:"{\"Mode\":493,\"Mask\":255}"`
+				T7 string `xyz:"mode=1_000" want:"{\"Mode\":1000}"`
+			}{},
+			model: struct {
+				Mode uint32 `pf:"mode,base=0" json:",omitempty"`
+				Mask int    `pf:"mask,base=0" json:",omitempty"`
+			}{},
+			targetTag: "xyz",
+			metaTag:   "pf",
+		},
 	}
 
 	for _, tc := range cases {
@@ -105,3 +253,265 @@ func TestFill(t *testing.T) {
 		})
 	}
 }
+
+func TestFillEscapedCommas(t *testing.T) {
+	type model struct {
+		Hosts []string `pt:"hosts,split=csv"`
+		Paths []string `pt:"paths,split=escaped"`
+		Name  string   `pt:"name"`
+		None  []string `pt:"none,split=none"`
+		Dir   []string `pt:"dir,split=csv"`
+	}
+	var got model
+	err := reflectutils.Tag{Value: `hosts="a\,b"\,c,paths=a\\\,b\,c,name=x\,y,none=a b,dir=c:\\,!other`}.Fill(&got)
+	require.NoError(t, err)
+	assert.Equal(t, model{
+		Hosts: []string{"a,b", "c"},
+		Paths: []string{"a,b", "c"},
+		Name:  `x\,y`,
+		None:  []string{"a b"},
+		Dir:   []string{`c:\`},
+	}, got)
+
+	// without csv or escaped fields, the tag is split on every comma
+	type plain struct {
+		Name  string `pt:"name"`
+		Other bool   `pt:"y"`
+	}
+	var p plain
+	require.NoError(t, reflectutils.Tag{Value: `name=x\,y`}.Fill(&p))
+	assert.Equal(t, plain{Name: `x\`, Other: true}, p)
+}
+
+func TestFillCollectAllErrors(t *testing.T) {
+	type model struct {
+		Name  string `pt:"name,required"`
+		Count int    `pt:"count"`
+		Ratio int    `pt:"ratio"`
+		Flag  bool   `pt:"flag"`
+	}
+	var got model
+	err := reflectutils.Tag{Value: "count=x,ratio=y,flag"}.Fill(&got)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Ratio", "last error wins")
+	assert.NotContains(t, err.Error(), "Count")
+
+	got = model{}
+	err = reflectutils.Tag{Value: "count=x,ratio=y,flag"}.Fill(&got, reflectutils.CollectAllErrors(true))
+	require.Error(t, err)
+	assert.True(t, got.Flag, "good fields are still set")
+	msg := err.Error()
+	assert.Contains(t, msg, "name is required")
+	assert.Contains(t, msg, `Count (pt:"count"): cannot set int from 'x'`)
+	assert.Contains(t, msg, `Ratio (pt:"ratio"): cannot set int from 'y'`)
+	var setErr *reflectutils.SetError
+	require.ErrorAs(t, err, &setErr)
+	assert.ErrorIs(t, err, strconv.ErrSyntax)
+
+	require.NoError(t, reflectutils.Tag{Value: "name=a"}.Fill(&got, reflectutils.CollectAllErrors(true)))
+}
+
+func TestFillUnknownElements(t *testing.T) {
+	type model struct {
+		Name    string `pt:"name"`
+		Verbose bool   `pt:"verbose,!quiet"`
+		Count   int
+	}
+	var got model
+	require.NoError(t, reflectutils.Tag{Value: "nmae=x,count=3"}.Fill(&got), "ignored by default")
+
+	err := reflectutils.Tag{Value: "nmae=x,quite,Count=3,zzz"}.Fill(&got, reflectutils.RejectUnknownElements(true))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown element(s): 'nmae' (did you mean 'name'?), 'quite' (did you mean 'quiet'?), 'zzz'`)
+	assert.Equal(t, 3, got.Count, "known elements are still filled")
+
+	require.NoError(t, reflectutils.Tag{Value: "name=x,!verbose"}.Fill(&got, reflectutils.RejectUnknownElements(true)))
+
+	type withLeftovers struct {
+		Name string            `pt:"0"`
+		Size int               `pt:"size"`
+		Rest map[string]string `pt:",leftovers"`
+	}
+	var wl withLeftovers
+	require.NoError(t, reflectutils.Tag{Value: "fred,size=3,color=red,big,!small"}.Fill(&wl, reflectutils.RejectUnknownElements(true)))
+	assert.Equal(t, withLeftovers{
+		Name: "fred",
+		Size: 3,
+		Rest: map[string]string{"color": "red", "big": "t", "small": "f"},
+	}, wl)
+
+	var badLeftovers struct {
+		Rest map[string]int `pt:",leftovers"`
+	}
+	require.Error(t, reflectutils.Tag{Value: "a=1"}.Fill(&badLeftovers))
+}
+
+func TestStructLeftoversRoundTrip(t *testing.T) {
+	type withLeftovers struct {
+		Size int               `pt:"size"`
+		Rest map[string]string `pt:",leftovers"`
+	}
+	var v withLeftovers
+	set, err := reflectutils.MakeStringSetter(reflect.TypeOf(v))
+	require.NoError(t, err)
+	require.NoError(t, set(reflect.ValueOf(&v).Elem(), "size=3,b=2,a=1"))
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, v.Rest)
+	get, err := reflectutils.MakeStringGetter(reflect.TypeOf(v))
+	require.NoError(t, err)
+	s, err := get(reflect.ValueOf(v))
+	require.NoError(t, err)
+	assert.Equal(t, "size=3,a=1,b=2", s)
+
+	var strict struct {
+		Size int `pt:"size"`
+	}
+	set, err = reflectutils.MakeStringSetter(reflect.TypeOf(strict))
+	require.NoError(t, err)
+	err = set(reflect.ValueOf(&strict).Elem(), "szie=3")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "did you mean 'size'?")
+}
+
+func TestFillDefaultAndEnum(t *testing.T) {
+	type model struct {
+		Name    string   `pt:"name,required"`
+		Level   string   `pt:"level,default=info,enum=debug|info|warn"`
+		Retries *int     `pt:"retries,default=3"`
+		Verbose bool     `pt:"verbose,!quiet,default=t"`
+		Modes   []string `pt:"modes,split=space,enum=r|w|x"`
+	}
+	cases := []struct {
+		tag   string
+		want  model
+		error string
+		path  string
+	}{
+		{
+			tag:  "name=a",
+			want: model{Name: "a", Level: "info", Retries: intPtr(3), Verbose: true},
+		},
+		{
+			tag:  "name=a,level=warn,retries=0,quiet,modes=r x",
+			want: model{Name: "a", Level: "warn", Retries: intPtr(0), Verbose: false, Modes: []string{"r", "x"}},
+		},
+		{tag: "level=debug", error: "name is required"},
+		{tag: "name=a,level=trace", error: "'trace' is not one of: debug, info, warn", path: "Level"},
+		{tag: "name=a,modes=r q", error: "'q' is not one of: r, w, x", path: "Modes[1]"},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.tag, func(t *testing.T) {
+			var got model
+			err := reflectutils.Tag{Value: tc.tag}.Fill(&got)
+			if tc.error == "" {
+				require.NoError(t, err)
+				assert.Equal(t, tc.want, got)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.error)
+			if tc.path != "" {
+				var setErr *reflectutils.SetError
+				require.True(t, errors.As(err, &setErr))
+				assert.Equal(t, tc.path, setErr.Path)
+			}
+		})
+	}
+}
+
+func intPtr(i int) *int { return &i }
+
+func TestFillNested(t *testing.T) {
+	type pool struct {
+		Max    int  `db:"max,required"`
+		Idle   int  `db:"idle"`
+		Shared bool `db:"shared"`
+	}
+	type flat struct {
+		Timeout int `db:"timeout"`
+	}
+	type model struct {
+		Name   string            `db:"0"`
+		Pool   pool              `db:"pool,group"`
+		Backup *pool             `db:"backup,group"`
+		Labels map[string]string `db:"labels,group"`
+		Limits map[string]int    `db:"limits,units=si,group"`
+		flat
+	}
+	var got model
+	err := reflectutils.Tag{Value: "main,pool.max=10,pool.idle=2,!pool.shared,labels.team=infra,labels.tier=1,limits.rows=2k,timeout=5"}.
+		Fill(&got, reflectutils.WithTag("db"), reflectutils.RejectUnknownElements(true))
+	require.NoError(t, err)
+	assert.Equal(t, model{
+		Name:   "main",
+		Pool:   pool{Max: 10, Idle: 2, Shared: false},
+		Labels: map[string]string{"team": "infra", "tier": "1"},
+		Limits: map[string]int{"rows": 2000},
+		flat:   flat{Timeout: 5},
+	}, got)
+
+	got = model{}
+	require.NoError(t, reflectutils.Tag{Value: "x,backup.max=1,backup.shared"}.Fill(&got, reflectutils.WithTag("db")))
+	if assert.NotNil(t, got.Backup) {
+		assert.Equal(t, pool{Max: 1, Shared: true}, *got.Backup)
+	}
+
+	err = reflectutils.Tag{Value: "x,pool.idle=2,limits.rows=lots,pool.mxa=3"}.
+		Fill(&got, reflectutils.WithTag("db"), reflectutils.RejectUnknownElements(true), reflectutils.CollectAllErrors(true))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Pool: max is required")
+	assert.Contains(t, err.Error(), "'mxa' (did you mean 'max'?)")
+	var setErr *reflectutils.SetError
+	require.True(t, errors.As(err, &setErr))
+	assert.Equal(t, "Limits[rows]", setErr.Path)
+
+	// without "group", struct fields are filled directly
+	type inner struct {
+		X int `pt:"x"`
+	}
+	var direct struct {
+		Name  string `pt:"0"`
+		Inner inner  `pt:"inner"`
+	}
+	require.NoError(t, reflectutils.Tag{Value: "n,x=1"}.Fill(&direct))
+	assert.Equal(t, 1, direct.Inner.X)
+}
+
+func TestStructNestedRoundTrip(t *testing.T) {
+	type pool struct {
+		Max  int `pt:"max"`
+		Idle int `pt:"idle"`
+	}
+	type conn struct {
+		Host   string            `pt:"host"`
+		Pool   *pool             `pt:"pool,group"`
+		Labels map[string]string `pt:"labels,group"`
+		Limits map[string]int    `pt:"limits,group"`
+		Rest   map[string]string `pt:",leftovers"`
+	}
+	const value = "host=db,pool.max=10,pool.idle=2,labels.team=infra,labels.tier=1,limits.rows=2000,owner=ops"
+	set, err := reflectutils.MakeStringSetter(reflect.TypeOf(conn{}))
+	require.NoError(t, err)
+	var c conn
+	require.NoError(t, set(reflect.ValueOf(&c).Elem(), value))
+	assert.Equal(t, conn{
+		Host:   "db",
+		Pool:   &pool{Max: 10, Idle: 2},
+		Labels: map[string]string{"team": "infra", "tier": "1"},
+		Limits: map[string]int{"rows": 2000},
+		Rest:   map[string]string{"owner": "ops"},
+	}, c)
+	get, err := reflectutils.MakeStringGetter(reflect.TypeOf(c))
+	require.NoError(t, err)
+	s, err := get(reflect.ValueOf(c))
+	require.NoError(t, err)
+	assert.Equal(t, value, s)
+
+	var back conn
+	require.NoError(t, set(reflect.ValueOf(&back).Elem(), s))
+	assert.Equal(t, c, back)
+
+	back = conn{}
+	require.NoError(t, set(reflect.ValueOf(&back).Elem(), "Rest.owner=ops"))
+	assert.Equal(t, map[string]string{"Rest.owner": "ops"}, back.Rest, "leftovers are not a group")
+}
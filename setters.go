@@ -1,28 +1,122 @@
 package reflectutils
 
 import (
+	"context"
 	"reflect"
-	"time"
+	"sync"
 )
 
-func init() {
-	RegisterStringSetter(time.ParseDuration)
+// SetterRegistry holds functions that transform strings into specific
+// types (setters) and specific types into strings (getters).  Lookups
+// that do not find a function in a registry continue in its parent,
+// if it has one.  A SetterRegistry is safe for concurrent use.
+//
+// The package-level functions RegisterStringSetter, RegisterStringGetter,
+// MakeStringSetter, and MakeStringGetter use the default registry which
+// is returned by DefaultSetterRegistry.  To use a different registry with
+// MakeStringSetter, pass WithRegistry.
+type SetterRegistry struct {
+	parent          *SetterRegistry
+	lock            sync.RWMutex
+	setters         map[reflect.Type]func(ctx context.Context, target reflect.Value, value string) error
+	getters         map[reflect.Type]func(value reflect.Value) string
+	setterFactories []SetterFactory
+	getterFactories []GetterFactory
+	implementations map[reflect.Type][]implementation
+}
+
+// SetterFactory is a function that may provide a setter for a type.  If
+// it does not handle the type, it should return nil.  SetterFactory
+// allows setters to be registered for interface types, for every type of
+// some kind, or for any other set of types that cannot be enumerated in
+// advance.
+type SetterFactory func(t reflect.Type) func(target reflect.Value, value string) error
+
+// GetterFactory is the counterpart to SetterFactory.  If it does not
+// handle the type, it should return nil.
+type GetterFactory func(t reflect.Type) func(value reflect.Value) string
+
+var defaultRegistry = NewSetterRegistry(nil)
+
+// NewSetterRegistry creates a new registry.  If parent is not nil,
+// the new registry inherits everything registered in the parent, now
+// or later, except where overridden in the new registry.  Pass
+// DefaultSetterRegistry() as the parent to inherit the pre-registered
+// setters and anything registered with RegisterStringSetter.
+func NewSetterRegistry(parent *SetterRegistry) *SetterRegistry {
+	return &SetterRegistry{
+		parent:  parent,
+		setters: make(map[reflect.Type]func(ctx context.Context, target reflect.Value, value string) error),
+		getters: make(map[reflect.Type]func(value reflect.Value) string),
+	}
+}
+
+// DefaultSetterRegistry returns the registry used by RegisterStringSetter
+// and by MakeStringSetter when no other registry is specified.
+func DefaultSetterRegistry() *SetterRegistry {
+	return defaultRegistry
+}
+
+// WithRegistry specifies which registry MakeStringSetter and MakeStringGetter
+// consult for registered setters and getters.  The default is
+// DefaultSetterRegistry().
+func WithRegistry(r *SetterRegistry) StringSetterArg {
+	return func(o *stringSetterOpts) {
+		o.registry = r
+	}
+}
+
+// WithSetterRegistry specifies which registry Tag.Fill and
+// FillInDefaultValuesWithOptions consult for registered setters,
+// including enums and implementations registered with RegisterEnumIn
+// and RegisterImplementationIn.  The default is DefaultSetterRegistry().
+func WithSetterRegistry(r *SetterRegistry) FillOptArg {
+	return func(o *fillOpt) {
+		o.registry = r
+	}
 }
 
-var settersByType = make(map[reflect.Type]reflect.Value)
+// MakeStringSetter is the same as the package-level MakeStringSetter
+// except that it consults this registry.
+func (r *SetterRegistry) MakeStringSetter(t reflect.Type, optArgs ...StringSetterArg) (func(target reflect.Value, value string) error, error) {
+	return MakeStringSetter(t, append([]StringSetterArg{WithRegistry(r)}, optArgs...)...)
+}
+
+// MakeStringSetterContext is the same as the package-level
+// MakeStringSetterContext except that it consults this registry.
+func (r *SetterRegistry) MakeStringSetterContext(t reflect.Type, optArgs ...StringSetterArg) (func(ctx context.Context, target reflect.Value, value string) error, error) {
+	return MakeStringSetterContext(t, append([]StringSetterArg{WithRegistry(r)}, optArgs...)...)
+}
+
+// MakeStringGetter is the same as the package-level MakeStringGetter
+// except that it consults this registry.
+func (r *SetterRegistry) MakeStringGetter(t reflect.Type, optArgs ...StringSetterArg) (func(value reflect.Value) (string, error), error) {
+	return MakeStringGetter(t, append([]StringSetterArg{WithRegistry(r)}, optArgs...)...)
+}
 
 // RegisterStringSetter registers functions that can be used to transform
 // strings into specific types.  The fn argument must be a function that
 // takes a string and returns an arbitrary type and an error.  An example
-// of such a function is time.ParseDuration.  Any call to RegisterStringSetter
-// with a value that is not a function of that sort will panic.
+// of such a function is time.ParseDuration.  The function may also take a
+// context.Context before the string in which case it is passed the context
+// given to a setter made with MakeStringSetterContext.  Any call to
+// RegisterStringSetter with a value that is not a function of that sort
+// will panic.
 //
-// RegisterStringSetter is not thread safe and should probably only be
-// used during init().
+// RegisterStringSetter registers into the default registry.  It is safe
+// for concurrent use but setters made before a registration do not
+// see the registration.
 //
 // These functions are used by MakeStringSetter() when there is an opportunity
 // to do so.
 func RegisterStringSetter(fn interface{}) {
+	defaultRegistry.RegisterStringSetter(fn)
+}
+
+// RegisterStringSetter registers a function that transforms strings into
+// a specific type.  See the package-level RegisterStringSetter.  Registering
+// a function for a type that already has one replaces it.
+func (r *SetterRegistry) RegisterStringSetter(fn interface{}) {
 	v := reflect.ValueOf(fn)
 	if !v.IsValid() {
 		panic("call to RegisterStringSetter with an invalid value")
@@ -30,17 +124,270 @@ func RegisterStringSetter(fn interface{}) {
 	if v.Type().Kind() != reflect.Func {
 		panic("call to RegisterStringSetter with something other than a function")
 	}
-	if v.Type().NumIn() != 1 {
+	withContext := v.Type().NumIn() == 2 && v.Type().In(0) == contextType
+	if v.Type().NumIn() != 1 && !withContext {
 		panic("call to RegisterStringSetter with something other than a function that takes one arg")
 	}
 	if v.Type().NumOut() != 2 {
 		panic("call to RegisterStringSetter with something other than a function that takes returns two values")
 	}
-	if v.Type().In(0) != reflect.TypeOf((*string)(nil)).Elem() {
+	if v.Type().In(v.Type().NumIn()-1) != reflect.TypeOf((*string)(nil)).Elem() {
 		panic("call to RegisterStringSetter with something other than a function that takes something other than string")
 	}
 	if v.Type().Out(1) != reflect.TypeOf((*error)(nil)).Elem() {
 		panic("call to RegisterStringSetter with something other than a function that returns something other than error")
 	}
-	settersByType[v.Type().Out(0)] = v
+	r.registerSetter(v.Type().Out(0), func(ctx context.Context, target reflect.Value, value string) error {
+		args := []reflect.Value{reflect.ValueOf(value)}
+		if withContext {
+			args = []reflect.Value{reflect.ValueOf(&ctx).Elem(), reflect.ValueOf(value)}
+		}
+		out := v.Call(args)
+		if !out[1].IsNil() {
+			return out[1].Interface().(error)
+		}
+		target.Set(out[0])
+		return nil
+	})
+}
+
+// RegisterStringGetter registers functions that can be used to transform
+// specific types into strings.  The fn argument must be a function that
+// takes an arbitrary type and returns a string.  An example of such a function
+// is time.Duration.String.  Any call to RegisterStringGetter with a value that
+// is not a function of that sort will panic.
+//
+// RegisterStringGetter is the counterpart to RegisterStringSetter and the
+// two should be registered together so that values round-trip.
+//
+// RegisterStringGetter registers into the default registry.  It is safe
+// for concurrent use but getters made before a registration do not
+// see the registration.
+//
+// These functions are used by MakeStringGetter() when there is an opportunity
+// to do so.
+func RegisterStringGetter(fn interface{}) {
+	defaultRegistry.RegisterStringGetter(fn)
+}
+
+// RegisterStringGetter registers a function that transforms a specific
+// type into a string.  See the package-level RegisterStringGetter.
+// Registering a function for a type that already has one replaces it.
+func (r *SetterRegistry) RegisterStringGetter(fn interface{}) {
+	v := reflect.ValueOf(fn)
+	if !v.IsValid() {
+		panic("call to RegisterStringGetter with an invalid value")
+	}
+	if v.Type().Kind() != reflect.Func {
+		panic("call to RegisterStringGetter with something other than a function")
+	}
+	if v.Type().NumIn() != 1 {
+		panic("call to RegisterStringGetter with something other than a function that takes one arg")
+	}
+	if v.Type().NumOut() != 1 {
+		panic("call to RegisterStringGetter with something other than a function that returns one value")
+	}
+	if v.Type().Out(0) != reflect.TypeOf((*string)(nil)).Elem() {
+		panic("call to RegisterStringGetter with something other than a function that returns string")
+	}
+	r.registerGetter(v.Type().In(0), func(value reflect.Value) string {
+		return v.Call([]reflect.Value{value})[0].String()
+	})
+}
+
+// RegisterStringSetterFactory registers a SetterFactory into the
+// default registry.  See SetterRegistry.RegisterStringSetterFactory.
+func RegisterStringSetterFactory(factory SetterFactory) {
+	defaultRegistry.RegisterStringSetterFactory(factory)
+}
+
+// RegisterStringSetterFactory registers a SetterFactory.  When making a
+// setter, MakeStringSetter first looks for a setter registered for the
+// exact type (in this registry and then its parents).  If none is found,
+// it asks the factories.  Factories registered later are asked first and
+// factories in this registry are asked before those in its parents.  The
+// first factory that does not return nil provides the setter.  Factories
+// are consulted before encoding.TextUnmarshaler, flag.Value, and the
+// built-in handling by kind.
+func (r *SetterRegistry) RegisterStringSetterFactory(factory SetterFactory) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.setterFactories = append(r.setterFactories, factory)
+}
+
+// RegisterStringGetterFactory registers a GetterFactory into the
+// default registry.  See SetterRegistry.RegisterStringGetterFactory.
+func RegisterStringGetterFactory(factory GetterFactory) {
+	defaultRegistry.RegisterStringGetterFactory(factory)
+}
+
+// RegisterStringGetterFactory registers a GetterFactory.  Getter factories
+// are consulted by MakeStringGetter in the same order as setter factories
+// are consulted by MakeStringSetter.
+func (r *SetterRegistry) RegisterStringGetterFactory(factory GetterFactory) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.getterFactories = append(r.getterFactories, factory)
+}
+
+// RegisterStringSetterFor is a type-safe alternative to RegisterStringSetter.
+// It registers into the default registry.  Unlike RegisterStringSetter,
+// the function is invoked directly rather than through reflection.
+//
+//	RegisterStringSetterFor(time.ParseDuration)
+func RegisterStringSetterFor[T any](fn func(string) (T, error)) {
+	RegisterStringSetterForIn(defaultRegistry, fn)
+}
+
+// RegisterStringSetterForIn is RegisterStringSetterFor for a specific registry.
+// Registering a function for a type that already has one replaces it.
+func RegisterStringSetterForIn[T any](r *SetterRegistry, fn func(string) (T, error)) {
+	RegisterStringSetterContextForIn(r, func(_ context.Context, s string) (T, error) {
+		return fn(s)
+	})
+}
+
+// RegisterStringSetterContextFor is RegisterStringSetterFor for functions
+// that need a context.  The function is passed the context given to a setter
+// made by MakeStringSetterContext or context.Background() for setters made
+// by MakeStringSetter.  This allows indirection like "env:NAME" or "file:/path":
+//
+//	RegisterStringSetterContextFor(func(ctx context.Context, s string) (Secret, error) {
+//		return secrets.Lookup(ctx, s)
+//	})
+func RegisterStringSetterContextFor[T any](fn func(context.Context, string) (T, error)) {
+	RegisterStringSetterContextForIn(defaultRegistry, fn)
+}
+
+// RegisterStringSetterContextForIn is RegisterStringSetterContextFor for a
+// specific registry.
+func RegisterStringSetterContextForIn[T any](r *SetterRegistry, fn func(context.Context, string) (T, error)) {
+	r.registerSetter(typeOf[T](), func(ctx context.Context, target reflect.Value, value string) error {
+		v, err := fn(ctx, value)
+		if err != nil {
+			return err
+		}
+		if target.CanSet() {
+			*(target.Addr().Interface().(*T)) = v
+		} else {
+			target.Set(reflect.ValueOf(&v).Elem())
+		}
+		return nil
+	})
+}
+
+// UnregisterStringSetterFor removes the setter for T from the default
+// registry.  It returns false if there wasn't one.
+func UnregisterStringSetterFor[T any]() bool {
+	return UnregisterStringSetterForIn[T](defaultRegistry)
+}
+
+// UnregisterStringSetterForIn removes the setter for T from a specific
+// registry.  It returns false if there wasn't one.  Setters registered
+// in parent registries are not affected and will be used if present.
+func UnregisterStringSetterForIn[T any](r *SetterRegistry) bool {
+	t := typeOf[T]()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, ok := r.setters[t]
+	delete(r.setters, t)
+	return ok
+}
+
+// RegisterStringGetterFor is a type-safe alternative to RegisterStringGetter.
+// It registers into the default registry.
+func RegisterStringGetterFor[T any](fn func(T) string) {
+	RegisterStringGetterForIn(defaultRegistry, fn)
+}
+
+// RegisterStringGetterForIn is RegisterStringGetterFor for a specific registry.
+// Registering a function for a type that already has one replaces it.
+func RegisterStringGetterForIn[T any](r *SetterRegistry, fn func(T) string) {
+	r.registerGetter(typeOf[T](), func(value reflect.Value) string {
+		if value.CanAddr() && value.CanInterface() {
+			return fn(*(value.Addr().Interface().(*T)))
+		}
+		return fn(value.Interface().(T))
+	})
+}
+
+// UnregisterStringGetterFor removes the getter for T from the default
+// registry.  It returns false if there wasn't one.
+func UnregisterStringGetterFor[T any]() bool {
+	return UnregisterStringGetterForIn[T](defaultRegistry)
+}
+
+// UnregisterStringGetterForIn removes the getter for T from a specific
+// registry.  It returns false if there wasn't one.
+func UnregisterStringGetterForIn[T any](r *SetterRegistry) bool {
+	t := typeOf[T]()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, ok := r.getters[t]
+	delete(r.getters, t)
+	return ok
+}
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func (r *SetterRegistry) registerSetter(t reflect.Type, setter func(ctx context.Context, target reflect.Value, value string) error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.setters[t] = setter
+}
+
+func (r *SetterRegistry) registerGetter(t reflect.Type, getter func(value reflect.Value) string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.getters[t] = getter
+}
+
+func (r *SetterRegistry) lookupSetter(t reflect.Type) (func(ctx context.Context, target reflect.Value, value string) error, bool) {
+	for c := r; c != nil; c = c.parent {
+		c.lock.RLock()
+		setter, ok := c.setters[t]
+		c.lock.RUnlock()
+		if ok {
+			return setter, true
+		}
+	}
+	for c := r; c != nil; c = c.parent {
+		c.lock.RLock()
+		factories := c.setterFactories
+		c.lock.RUnlock()
+		for i := len(factories) - 1; i >= 0; i-- {
+			if setter := factories[i](t); setter != nil {
+				return func(_ context.Context, target reflect.Value, value string) error {
+					return setter(target, value)
+				}, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (r *SetterRegistry) lookupGetter(t reflect.Type) (func(value reflect.Value) string, bool) {
+	for c := r; c != nil; c = c.parent {
+		c.lock.RLock()
+		getter, ok := c.getters[t]
+		c.lock.RUnlock()
+		if ok {
+			return getter, true
+		}
+	}
+	for c := r; c != nil; c = c.parent {
+		c.lock.RLock()
+		factories := c.getterFactories
+		c.lock.RUnlock()
+		for i := len(factories) - 1; i >= 0; i-- {
+			if getter := factories[i](t); getter != nil {
+				return getter, true
+			}
+		}
+	}
+	return nil, false
 }
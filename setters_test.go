@@ -0,0 +1,241 @@
+package reflectutils_test
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/muir/reflectutils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Celsius float64
+
+func TestSetterRegistry(t *testing.T) {
+	seconds := func(s string) (time.Duration, error) {
+		i, err := strconv.Atoi(s)
+		return time.Duration(i) * time.Second, err
+	}
+
+	child := reflectutils.NewSetterRegistry(reflectutils.DefaultSetterRegistry())
+	child.RegisterStringSetter(seconds)
+	orphan := reflectutils.NewSetterRegistry(nil)
+
+	set := func(r *reflectutils.SetterRegistry, target interface{}, value string) error {
+		v := reflect.ValueOf(target).Elem()
+		fn, err := reflectutils.MakeStringSetter(v.Type(), reflectutils.WithRegistry(r))
+		require.NoError(t, err)
+		return fn(v, value)
+	}
+
+	var d time.Duration
+	require.NoError(t, set(child, &d, "90"))
+	assert.Equal(t, 90*time.Second, d, "child override")
+	require.NoError(t, set(reflectutils.DefaultSetterRegistry(), &d, "1m"))
+	assert.Equal(t, time.Minute, d, "default is not changed")
+	require.Error(t, set(orphan, &d, "1m"), "orphan has no duration parser")
+	require.NoError(t, set(orphan, &d, "12"), "orphan parses durations as integers")
+	assert.Equal(t, time.Duration(12), d)
+
+	// Registrations in the parent are visible in the child
+	reflectutils.RegisterStringSetter(func(s string) (Celsius, error) {
+		f, err := strconv.ParseFloat(s, 64)
+		return Celsius(f), err
+	})
+	reflectutils.RegisterStringGetter(func(c Celsius) string {
+		return strconv.FormatFloat(float64(c), 'f', -1, 64) + "C"
+	})
+	var c Celsius
+	require.NoError(t, set(child, &c, "21.5"))
+	assert.Equal(t, Celsius(21.5), c)
+	get, err := child.MakeStringGetter(reflect.TypeOf(c))
+	require.NoError(t, err)
+	s, err := get(reflect.ValueOf(c))
+	require.NoError(t, err)
+	assert.Equal(t, "21.5C", s)
+
+	fn, err := child.MakeStringSetter(reflect.TypeOf([]time.Duration{}))
+	require.NoError(t, err)
+	var ds []time.Duration
+	require.NoError(t, fn(reflect.ValueOf(&ds).Elem(), "1,2"))
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, ds, "registry applies to elements")
+}
+
+func TestSetterRegistryConcurrency(t *testing.T) {
+	r := reflectutils.NewSetterRegistry(reflectutils.DefaultSetterRegistry())
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			r.RegisterStringSetter(func(s string) (Celsius, error) {
+				return Celsius(len(s)), nil
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := r.MakeStringSetter(reflect.TypeOf(Celsius(0)))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+}
+
+type Fahrenheit float64
+
+func TestRegisterStringSetterFor(t *testing.T) {
+	r := reflectutils.NewSetterRegistry(reflectutils.DefaultSetterRegistry())
+	reflectutils.RegisterStringSetterForIn(r, func(s string) (Fahrenheit, error) {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, "F"), 64)
+		return Fahrenheit(f), err
+	})
+	reflectutils.RegisterStringGetterForIn(r, func(f Fahrenheit) string {
+		return strconv.FormatFloat(float64(f), 'f', -1, 64) + "F"
+	})
+
+	type model struct {
+		Temp  Fahrenheit   `pt:"temp"`
+		Temps []Fahrenheit `pt:"temps,split=space"`
+	}
+	var m model
+	set, err := r.MakeStringSetter(reflect.TypeOf(m.Temps))
+	require.NoError(t, err)
+	require.NoError(t, set(reflect.ValueOf(&m.Temps).Elem(), "70F,71.5F"))
+	assert.Equal(t, []Fahrenheit{70, 71.5}, m.Temps)
+	get, err := r.MakeStringGetter(reflect.TypeOf(m.Temps))
+	require.NoError(t, err)
+	s, err := get(reflect.ValueOf(m.Temps))
+	require.NoError(t, err)
+	assert.Equal(t, "70F,71.5F", s)
+
+	require.Error(t, set(reflect.ValueOf(&m.Temps).Elem(), "hot"))
+
+	// values reached through unexported fields
+	var hidden struct {
+		temp Fahrenheit
+	}
+	hidden.temp = 12
+	get, err = r.MakeStringGetter(reflect.TypeOf(hidden.temp))
+	require.NoError(t, err)
+	s, err = get(reflect.ValueOf(&hidden).Elem().Field(0))
+	require.NoError(t, err)
+	assert.Equal(t, "12F", s)
+	_, err = get(reflect.ValueOf(hidden).Field(0))
+	require.Error(t, err, "not addressable")
+
+	assert.True(t, reflectutils.UnregisterStringSetterForIn[Fahrenheit](r))
+	assert.False(t, reflectutils.UnregisterStringSetterForIn[Fahrenheit](r))
+	assert.True(t, reflectutils.UnregisterStringGetterForIn[Fahrenheit](r))
+	set, err = r.MakeStringSetter(reflect.TypeOf(m.Temp))
+	require.NoError(t, err)
+	require.Error(t, set(reflect.ValueOf(&m.Temp).Elem(), "70F"), "no longer registered")
+
+	// The default registry is visible to Tag.Fill and FillInDefaultValues
+	reflectutils.RegisterStringSetterFor(func(s string) (Fahrenheit, error) {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, "F"), 64)
+		return Fahrenheit(f), err
+	})
+	defer reflectutils.UnregisterStringSetterFor[Fahrenheit]()
+	m = model{}
+	require.NoError(t, reflectutils.Tag{Value: "temp=80F,temps=1F 2F"}.Fill(&m))
+	assert.Equal(t, model{Temp: 80, Temps: []Fahrenheit{1, 2}}, m)
+	var d struct {
+		Temp Fahrenheit `default:"32F"`
+	}
+	require.NoError(t, reflectutils.FillInDefaultValues(&d))
+	assert.Equal(t, Fahrenheit(32), d.Temp)
+}
+
+type Shape interface {
+	Area() float64
+}
+
+type Square float64
+
+func (s Square) Area() float64 { return float64(s * s) }
+
+type Upper string
+
+func TestSetterFactory(t *testing.T) {
+	shapeType := reflect.TypeOf((*Shape)(nil)).Elem()
+	r := reflectutils.NewSetterRegistry(reflectutils.DefaultSetterRegistry())
+	r.RegisterStringSetterFactory(func(t reflect.Type) func(target reflect.Value, value string) error {
+		if t != shapeType {
+			return nil
+		}
+		return func(target reflect.Value, value string) error {
+			side, err := strconv.ParseFloat(strings.TrimPrefix(value, "square:"), 64)
+			if err != nil {
+				return err
+			}
+			target.Set(reflect.ValueOf(Square(side)))
+			return nil
+		}
+	})
+	r.RegisterStringSetterFactory(func(t reflect.Type) func(target reflect.Value, value string) error {
+		if t.Kind() != reflect.String || t.Name() != "Upper" {
+			return nil
+		}
+		return func(target reflect.Value, value string) error {
+			target.SetString(strings.ToUpper(value))
+			return nil
+		}
+	})
+	r.RegisterStringGetterFactory(func(t reflect.Type) func(value reflect.Value) string {
+		if t != shapeType {
+			return nil
+		}
+		return func(value reflect.Value) string {
+			return "square:" + strconv.FormatFloat(float64(value.Interface().(Square)), 'g', -1, 64)
+		}
+	})
+
+	var shapes []Shape
+	set, err := r.MakeStringSetter(reflect.TypeOf(shapes))
+	require.NoError(t, err)
+	require.NoError(t, set(reflect.ValueOf(&shapes).Elem(), "square:2,square:3"))
+	assert.Equal(t, []Shape{Square(2), Square(3)}, shapes)
+
+	get, err := r.MakeStringGetter(reflect.TypeOf(shapes))
+	require.NoError(t, err)
+	s, err := get(reflect.ValueOf(shapes))
+	require.NoError(t, err)
+	assert.Equal(t, "square:2,square:3", s)
+
+	var u Upper
+	set, err = r.MakeStringSetter(reflect.TypeOf(u))
+	require.NoError(t, err)
+	require.NoError(t, set(reflect.ValueOf(&u).Elem(), "shout"))
+	assert.Equal(t, Upper("SHOUT"), u)
+
+	_, err = reflectutils.MakeStringSetter(reflect.TypeOf(shapes))
+	require.Error(t, err, "not in the default registry")
+}
+
+type Kelvin float64
+
+func TestFillWithSetterRegistry(t *testing.T) {
+	r := reflectutils.NewSetterRegistry(reflectutils.DefaultSetterRegistry())
+	reflectutils.RegisterStringSetterForIn(r, func(s string) (Kelvin, error) {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, "K"), 64)
+		return Kelvin(f), err
+	})
+	var model struct {
+		Temp  Kelvin   `pt:"temp" default:"273.15K"`
+		Temps []Kelvin `pt:"temps,split=space"`
+	}
+	require.Error(t, reflectutils.Tag{Value: "temp=300K"}.Fill(&model), "not in the default registry")
+	require.NoError(t, reflectutils.Tag{Value: "temp=300K,temps=1K 2K"}.Fill(&model, reflectutils.WithSetterRegistry(r)))
+	assert.Equal(t, Kelvin(300), model.Temp)
+	assert.Equal(t, []Kelvin{1, 2}, model.Temps)
+
+	model.Temp = 0
+	require.Error(t, reflectutils.FillInDefaultValues(&model), "not in the default registry")
+	require.NoError(t, reflectutils.FillInDefaultValuesWithOptions(&model, reflectutils.WithSetterRegistry(r)))
+	assert.Equal(t, Kelvin(273.15), model.Temp)
+}
@@ -0,0 +1,161 @@
+package reflectutils
+
+import (
+	"encoding/csv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/memsql/errors"
+)
+
+// SplitStyle controls how strings are split into the elements of
+// arrays, slices, and maps.
+type SplitStyle int
+
+const (
+	// SplitPlain splits on every occurrence of the separator.  There is
+	// no way to include the separator in an element.  This is the default.
+	SplitPlain SplitStyle = iota
+
+	// SplitCSV splits following the rules of encoding/csv: elements may be
+	// quoted with double quotes (") and a quote inside a quoted element is
+	// written as two quotes.  For example: `"x,y",z` has two elements.
+	// The separator must be a single character.
+	SplitCSV
+
+	// SplitEscaped splits on the separator except where the separator is
+	// preceded by a backslash.  A backslash escapes any character, including
+	// itself, so `a\,b,c\\` has two elements: "a,b" and `c\`.
+	SplitEscaped
+)
+
+// WithSplitStyle specifies how strings are split into slices, arrays,
+// and maps.  The default is SplitPlain.
+//
+// With SplitCSV and SplitEscaped, providing more values than will fit in an
+// array is an error rather than leaving the remainder in the last element.
+func WithSplitStyle(style SplitStyle) StringSetterArg {
+	return func(o *stringSetterOpts) {
+		o.splitStyle = style
+	}
+}
+
+// checkSplit validates that the split options are usable
+func (opts stringSetterOpts) checkSplit() error {
+	switch opts.splitStyle {
+	case SplitPlain, SplitEscaped:
+		return nil
+	case SplitCSV:
+		if opts.split == "" {
+			return nil
+		}
+		r, size := utf8.DecodeRuneInString(opts.split)
+		if size != len(opts.split) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+			return errors.Errorf("separator '%s' cannot be used for CSV splitting", opts.split)
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown split style %d", opts.splitStyle)
+	}
+}
+
+// splitValue breaks value into elements.  If n is not negative,
+// at most n elements are returned.
+func (opts stringSetterOpts) splitValue(value string, n int) ([]string, error) {
+	if opts.split == "" {
+		return []string{value}, nil
+	}
+	switch opts.splitStyle {
+	case SplitCSV:
+		if value == "" {
+			return []string{value}, nil
+		}
+		r := csv.NewReader(strings.NewReader(value))
+		r.Comma, _ = utf8.DecodeRuneInString(opts.split)
+		r.FieldsPerRecord = -1
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(records) != 1 {
+			return nil, errors.Errorf("value must be a single line of CSV, found %d lines", len(records))
+		}
+		if n >= 0 && len(records[0]) > n {
+			return nil, errors.Errorf("too many values (%d) for %d elements", len(records[0]), n)
+		}
+		return records[0], nil
+	case SplitEscaped:
+		var values []string
+		var current strings.Builder
+		for i := 0; i < len(value); i++ {
+			switch {
+			case value[i] == '\\':
+				if i+1 == len(value) {
+					return nil, errors.Errorf("value '%s' ends with an incomplete escape", value)
+				}
+				i++
+				current.WriteByte(value[i])
+			case strings.HasPrefix(value[i:], opts.split):
+				if n >= 0 && len(values) == n-1 {
+					return nil, errors.Errorf("too many values for %d elements", n)
+				}
+				values = append(values, current.String())
+				current.Reset()
+				i += len(opts.split) - 1
+			default:
+				current.WriteByte(value[i])
+			}
+		}
+		return append(values, current.String()), nil
+	default:
+		if n >= 0 {
+			return strings.SplitN(value, opts.split, n), nil
+		}
+		return strings.Split(value, opts.split), nil
+	}
+}
+
+// joinValues is the inverse of splitValue.  It is an error if the
+// values could not be split apart again.
+func (opts stringSetterOpts) joinValues(values []string) (string, error) {
+	if opts.split == "" && len(values) > 1 {
+		return "", errors.Errorf("cannot format %d values without a separator", len(values))
+	}
+	switch opts.splitStyle {
+	case SplitCSV:
+		if opts.split == "" || len(values) == 0 {
+			return strings.Join(values, opts.split), nil
+		}
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		w.Comma, _ = utf8.DecodeRuneInString(opts.split)
+		err := w.Write(values)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return "", errors.WithStack(err)
+		}
+		return strings.TrimSuffix(b.String(), "\n"), nil
+	case SplitEscaped:
+		if opts.split == "" {
+			return strings.Join(values, opts.split), nil
+		}
+		escaped := make([]string, len(values))
+		for i, v := range values {
+			v = strings.ReplaceAll(v, `\`, `\\`)
+			escaped[i] = strings.ReplaceAll(v, opts.split, `\`+opts.split)
+		}
+		return strings.Join(escaped, opts.split), nil
+	default:
+		if opts.split != "" {
+			for _, v := range values {
+				if strings.Contains(v, opts.split) {
+					return "", errors.Errorf("cannot format '%s' because it contains the separator '%s', use SplitCSV or SplitEscaped", v, opts.split)
+				}
+			}
+		}
+		return strings.Join(values, opts.split), nil
+	}
+}
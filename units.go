@@ -0,0 +1,259 @@
+package reflectutils
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/memsql/errors"
+)
+
+// Units controls whether numbers may have unit suffixes like
+// "k" and "Mi".  See WithUnits.
+type Units int
+
+const (
+	// UnitsNone does not allow suffixes.  This is the default.
+	UnitsNone Units = iota
+
+	// UnitsSI allows SI suffixes (k, M, G, T, P, E) which are powers
+	// of 1000, and IEC suffixes (Ki, Mi, Gi, Ti, Pi, Ei) which are powers
+	// of 1024.  "K" is accepted as a synonym for "k".  For example,
+	// "10k" is 10000 and "1.5Mi" is 1572864.
+	UnitsSI
+
+	// UnitsBytes is like UnitsSI but allows an optional trailing "B" so
+	// "64MiB", "1.5GB", "10KB" and "512B" are all accepted.
+	UnitsBytes
+)
+
+type unitSuffix struct {
+	suffix string
+	mult   uint64
+}
+
+// unitSuffixes is ordered from largest to smallest so that formatting
+// can pick the largest unit that represents a value exactly.
+var unitSuffixes = []unitSuffix{
+	{"Ei", 1 << 60},
+	{"E", 1e18},
+	{"Pi", 1 << 50},
+	{"P", 1e15},
+	{"Ti", 1 << 40},
+	{"T", 1e12},
+	{"Gi", 1 << 30},
+	{"G", 1e9},
+	{"Mi", 1 << 20},
+	{"M", 1e6},
+	{"Ki", 1 << 10},
+	{"k", 1e3},
+}
+
+// WithUnits allows integers and floats to be written with unit
+// suffixes.  With units, numbers are always parsed as decimal and
+// WithIntegerBase is ignored.  Integer targets must end up with whole
+// numbers: "1.5k" can be stored in an int but "1.5" cannot.  Values
+// that do not fit the target type return a RangeError.
+//
+// MakeStringGetter formats with the largest unit that represents the
+// value exactly.  With UnitsSI, only the powers of 1000 are used for
+// output.  With UnitsBytes both kinds are used and a "B" is appended,
+// so 1048576 formats as "1MiB" and 2000000 as "2MB".
+func WithUnits(units Units) StringSetterArg {
+	return func(o *stringSetterOpts) {
+		o.units = units
+	}
+}
+
+func checkUnits(units Units) error {
+	switch units {
+	case UnitsNone, UnitsSI, UnitsBytes:
+		return nil
+	default:
+		return errors.Errorf("unknown units %d", units)
+	}
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uintptr, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// splitUnits separates a value into a number and a multiplier
+func splitUnits(value string, units Units) (string, uint64, error) {
+	s := strings.TrimSpace(value)
+	if units == UnitsBytes {
+		s = strings.TrimSuffix(s, "B")
+	}
+	i := strings.LastIndexAny(s, "0123456789.") + 1
+	number, suffix := strings.TrimSpace(s[:i]), s[i:]
+	if suffix == "" {
+		return number, 1, nil
+	}
+	if suffix == "K" {
+		suffix = "k"
+	}
+	for _, u := range unitSuffixes {
+		if u.suffix == suffix {
+			return number, u.mult, nil
+		}
+	}
+	return "", 0, errors.Errorf("unknown unit '%s' in '%s'", suffix, value)
+}
+
+func parseUnits(value string, units Units) (*big.Rat, error) {
+	number, mult, err := splitUnits(value, units)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := new(big.Rat).SetString(number)
+	if !ok || number == "" || strings.Contains(number, "/") {
+		return nil, errors.Errorf("invalid number '%s'", value)
+	}
+	return r.Mul(r, new(big.Rat).SetInt(new(big.Int).SetUint64(mult))), nil
+}
+
+func makeUnitsSetter(t reflect.Type, opts stringSetterOpts) (func(ctx context.Context, target reflect.Value, value string) error, error) {
+	if err := checkUnits(opts.units); err != nil {
+		return nil, err
+	}
+	bits := uint(t.Bits())
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return func(_ context.Context, target reflect.Value, value string) error {
+			// without a suffix, allow everything that ParseFloat allows
+			s := strings.TrimSpace(value)
+			if opts.units == UnitsBytes {
+				s = strings.TrimSuffix(s, "B")
+			}
+			if f, err := strconv.ParseFloat(s, t.Bits()); err == nil {
+				target.SetFloat(f)
+				return nil
+			} else if errors.Is(err, strconv.ErrRange) {
+				return numberError(t, value, err)
+			}
+			r, err := parseUnits(value, opts.units)
+			if err != nil {
+				return err
+			}
+			var f float64
+			if t.Kind() == reflect.Float32 {
+				f32, _ := r.Float32()
+				f = float64(f32)
+			} else {
+				f, _ = r.Float64()
+			}
+			if math.IsInf(f, 0) {
+				return errors.WithStack(&RangeError{Type: t, Value: value})
+			}
+			target.SetFloat(f)
+			return nil
+		}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(_ context.Context, target reflect.Value, value string) error {
+			r, err := parseUnits(value, opts.units)
+			if err != nil {
+				return err
+			}
+			if !r.IsInt() {
+				return errors.Errorf("value '%s' is not a whole number", value)
+			}
+			n := r.Num()
+			if !n.IsInt64() || n.Int64()<<(64-bits)>>(64-bits) != n.Int64() {
+				return errors.WithStack(&RangeError{Type: t, Value: value})
+			}
+			target.SetInt(n.Int64())
+			return nil
+		}, nil
+	default:
+		return func(_ context.Context, target reflect.Value, value string) error {
+			r, err := parseUnits(value, opts.units)
+			if err != nil {
+				return err
+			}
+			if !r.IsInt() {
+				return errors.Errorf("value '%s' is not a whole number", value)
+			}
+			n := r.Num()
+			if !n.IsUint64() || (bits < 64 && n.Uint64()>>bits != 0) {
+				return errors.WithStack(&RangeError{Type: t, Value: value})
+			}
+			target.SetUint(n.Uint64())
+			return nil
+		}, nil
+	}
+}
+
+func makeUnitsGetter(t reflect.Type, opts stringSetterOpts) (func(value reflect.Value) (string, error), error) {
+	if err := checkUnits(opts.units); err != nil {
+		return nil, err
+	}
+	var tail string
+	if opts.units == UnitsBytes {
+		tail = "B"
+	}
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return func(value reflect.Value) (string, error) {
+			f := value.Float()
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return strconv.FormatFloat(f, 'g', -1, t.Bits()), nil
+			}
+			for _, u := range unitSuffixes {
+				if !opts.useUnit(u) || math.Abs(f) < float64(u.mult) {
+					continue
+				}
+				s := strconv.FormatFloat(f/float64(u.mult), 'g', -1, t.Bits()) + u.suffix + tail
+				// only use the unit if the result parses back to the same value
+				r, err := parseUnits(s, opts.units)
+				if err != nil {
+					continue
+				}
+				if t.Kind() == reflect.Float32 {
+					if f32, _ := r.Float32(); float64(f32) == f {
+						return s, nil
+					}
+				} else if f64, _ := r.Float64(); f64 == f {
+					return s, nil
+				}
+			}
+			return strconv.FormatFloat(f, 'g', -1, t.Bits()) + tail, nil
+		}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(value reflect.Value) (string, error) {
+			i := value.Int()
+			if i < 0 {
+				return "-" + opts.formatUnits(-uint64(i)) + tail, nil
+			}
+			return opts.formatUnits(uint64(i)) + tail, nil
+		}, nil
+	default:
+		return func(value reflect.Value) (string, error) {
+			return opts.formatUnits(value.Uint()) + tail, nil
+		}, nil
+	}
+}
+
+// useUnit reports if a unit is used for output
+func (opts stringSetterOpts) useUnit(u unitSuffix) bool {
+	return opts.units == UnitsBytes || !strings.HasSuffix(u.suffix, "i")
+}
+
+func (opts stringSetterOpts) formatUnits(n uint64) string {
+	if n != 0 {
+		for _, u := range unitSuffixes {
+			if opts.useUnit(u) && n%u.mult == 0 {
+				return strconv.FormatUint(n/u.mult, 10) + u.suffix
+			}
+		}
+	}
+	return strconv.FormatUint(n, 10)
+}
@@ -1,12 +1,14 @@
 package reflectutils
 
 import (
+	"context"
 	"encoding"
 	"encoding/json"
 	"flag"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/memsql/errors"
 )
@@ -17,9 +19,62 @@ var (
 )
 
 type stringSetterOpts struct {
-	split       string
-	sliceAppend bool
-	forceJSON   bool
+	split         string
+	innerSplits   []string
+	kvSplit       string
+	sliceAppend   bool
+	forceJSON     bool
+	base          int
+	splitStyle    SplitStyle
+	registry      *SetterRegistry
+	timeLayouts   []string
+	units         Units
+	durationStyle DurationStyle
+	byteEncoding  ByteEncoding
+	strictArrays  bool
+	sliceMin      int
+	sliceMax      int
+}
+
+func makeStringSetterOpts(optArgs []StringSetterArg) stringSetterOpts {
+	opts := stringSetterOpts{
+		split:       ",",
+		kvSplit:     "=",
+		sliceAppend: true,
+		base:        10,
+		registry:    defaultRegistry,
+		timeLayouts: []string{time.RFC3339Nano},
+	}
+	for _, f := range optArgs {
+		f(&opts)
+	}
+	return opts
+}
+
+// elementOpts returns the options used for the elements of
+// arrays, slices, and maps: the split moves down one level.  The
+// split style applies only to the level it was set for.  The
+// key/value separator is kept until a map or struct uses it so
+// that maps inside of slices can have their own separator.
+func (opts stringSetterOpts) elementOpts() stringSetterOpts {
+	if len(opts.innerSplits) > 0 {
+		opts.split = opts.innerSplits[0]
+		opts.innerSplits = opts.innerSplits[1:]
+	} else {
+		opts.split = ","
+	}
+	opts.splitStyle = SplitPlain
+	opts.sliceMin, opts.sliceMax = 0, 0
+	return opts
+}
+
+// mapElementOpts is elementOpts for the keys and values of maps
+// and the fields of structs.  They have used the key/value
+// separator so inner levels go back to the default.
+func (opts stringSetterOpts) mapElementOpts() stringSetterOpts {
+	opts = opts.elementOpts()
+	opts.kvSplit = "="
+	return opts
 }
 
 type StringSetterArg func(*stringSetterOpts)
@@ -35,16 +90,86 @@ func WithSplitOn(s string) StringSetterArg {
 	}
 }
 
+// WithSplitOnLevels specifies how to split strings for nested
+// arrays, slices, and maps.  The outer split is used for the
+// outermost collection, the first inner split is used for the
+// elements of that collection, and so on.  For example, to set
+// a [][]int from "1,2;3,4":
+//
+//	WithSplitOnLevels(";", ",")
+//
+// Levels that are not specified split on comma (,).  WithSplitStyle
+// and WithKeyValueSplitOn apply only to the outermost level.  Pointers do
+// not count as a level.  Map keys and values are both one level
+// below the map.
+func WithSplitOnLevels(outer string, inner ...string) StringSetterArg {
+	return func(o *stringSetterOpts) {
+		o.split = outer
+		o.innerSplits = inner
+	}
+}
+
+// WithKeyValueSplitOn specifies how to split map entries into
+// a key and a value.  If unspecified, entries will be split
+// on equals (=).  Entries are split on the first occurrence
+// of the separator so values may contain the separator.
+// The separator applies to the outermost map or struct, even
+// when it is inside of a slice or array.  Maps and structs
+// inside of it split on equals (=).
+func WithKeyValueSplitOn(s string) StringSetterArg {
+	return func(o *stringSetterOpts) {
+		o.kvSplit = s
+	}
+}
+
 // Controls the behavior for setting existing existing slices.
 // If this is true (the default) then additional setting to a
 // slice appends to the existing slice.  If false, slices are
-// replaced.
+// replaced.  Maps are treated the same way: when true, new
+// entries are merged into an existing map; when false, the
+// map is replaced.
 func SliceAppend(b bool) StringSetterArg {
 	return func(o *stringSetterOpts) {
 		o.sliceAppend = b
 	}
 }
 
+// StrictArrayLength controls what happens when the number of values
+// does not match the length of an array.  If false (the default), extra
+// values are left unsplit in the last element and missing values
+// leave elements unchanged: "1,2,3" sets a [2]string to ["1", "2,3"].
+// If true, the number of values must exactly match the length of the
+// array or it is an error.  This applies to nested arrays too.
+func StrictArrayLength(b bool) StringSetterArg {
+	return func(o *stringSetterOpts) {
+		o.strictArrays = b
+	}
+}
+
+// WithSliceLengthLimits requires that slices have at least min and
+// at most max elements after they are set.  With SliceAppend, that
+// includes elements that were already in the slice.  A max of zero
+// means there is no maximum.  The limits only apply to the outermost
+// slice, not to the elements of a slice of slices.
+func WithSliceLengthLimits(min, max int) StringSetterArg {
+	return func(o *stringSetterOpts) {
+		o.sliceMin = min
+		o.sliceMax = max
+	}
+}
+
+// WithIntegerBase specifies the base used to parse integers.  The
+// default is 10.  A base of 0 means that the base is implied by
+// the string's prefix following the syntax for Go integer
+// literals: "0x1F", "0o755", "0b1010", and "1_000_000" are all
+// accepted.  See strconv.ParseInt.  Bases other than 0 must be between
+// 2 and 36.
+func WithIntegerBase(base int) StringSetterArg {
+	return func(o *stringSetterOpts) {
+		o.base = base
+	}
+}
+
 // ForceJSON controls if types will be decoded with JSON
 // unmarshal. This overrides normal decoding patterns. The default
 // is false.
@@ -58,27 +183,95 @@ func ForceJSON(b bool) StringSetterArg {
 // Based on type, it returns a function to do the work.  It is assumed that the
 // reflect.Type matches the reflect.Value.  If not, panic is likely.
 //
+// Numbers are parsed according to the size of the target type.  Numbers
+// that do not fit return an error that wraps RangeError.
+//
 // For arrays and slices, strings are split on comma to create the values for the
-// elements.
+// elements.  The empty string has no values so it sets a slice to nil (or
+// appends nothing).  This is a change: it used to set a slice to a single
+// empty element, []string{""} for example.  Use WithSplitStyle to allow
+// elements that contain the separator.
+//
+// For maps, strings are split on comma to create entries and then each entry is
+// split on equals (=) to separate the key from the value: "a=1,b=2".
+// The empty string has no entries.
+//
+// Nested arrays, slices, and maps are supported.  Use WithSplitOnLevels to
+// specify a different separator for each level of nesting.
 //
 // Any type that matches a type registered with RegisterStringSetter will be
 // unpacked with the corresponding function.  A string setter is pre-registered
-// for time.Duration
+// for time.Duration and for other standard library types that do not implement
+// encoding.TextUnmarshaler: *url.URL, *regexp.Regexp, *net.IPNet (CIDR),
+// and *time.Location.  os.FileMode is an integer: use WithIntegerBase(8)
+// to parse it as octal.  time.Time is parsed with the layouts given by
+// WithTimeLayouts, RFC3339 by default.  After that, setter factories
+// registered with RegisterStringSetterFactory are consulted.  Use
+// WithRegistry to consult a different SetterRegistry.
 // Anything that implements encoding.TextUnmarshaler will be unpacked that way.
 // Anything that implements flag.Value will be unpacked that way.
 //
-// Maps, structs, channels, interfaces, channels, and funcs are not supported unless
+// Structs are set from a list of key=value elements, "host=db,port=5432",
+// with fields matched the same way as Tag.Fill using "pt" struct tags or,
+// failing that, field names.  Elements that do not match a field are an
+// error as are missing elements for fields tagged "required":
+//
+//	type Addr struct {
+//		Host string `pt:"host,required"`
+//		Port int    `pt:"port"`
+//	}
+//
+// Errors that occur while setting return a SetError that identifies
+// the element that could not be set:
+//
+//	var setErr *SetError
+//	if errors.As(err, &setErr) {
+//		fmt.Println(setErr.Path, setErr.Value)
+//	}
+//
+// Channels, interfaces, and funcs are not supported unless
 // they happen to implent encoding.TextUnmarshaler.
 func MakeStringSetter(t reflect.Type, optArgs ...StringSetterArg) (func(target reflect.Value, value string) error, error) {
-	opts := stringSetterOpts{
-		split:       ",",
-		sliceAppend: true,
+	set, err := MakeStringSetterContext(t, optArgs...)
+	if err != nil {
+		return nil, err
 	}
-	for _, f := range optArgs {
-		f(&opts)
+	return func(target reflect.Value, value string) error {
+		return set(context.Background(), target, value)
+	}, nil
+}
+
+// MakeStringSetterContext is MakeStringSetter for setters that need a
+// context.  The context is passed to setters registered with
+// RegisterStringSetterContextFor (or with RegisterStringSetter given a
+// function that takes a context.Context) so that they can, for example,
+// look up secrets or read files with cancellation and deadlines.  The
+// context is checked before setting and an error is returned if it is
+// already done.
+//
+// When a struct is being set, from MakeStringSetterContext, Tag.Fill, or
+// FillInDefaultValues, the context passed to setters for its fields
+// also provides the struct.  See SurroundingStruct.
+func MakeStringSetterContext(t reflect.Type, optArgs ...StringSetterArg) (func(ctx context.Context, target reflect.Value, value string) error, error) {
+	set, err := makeStringSetter(t, makeStringSetterOpts(optArgs))
+	if err != nil {
+		return nil, err
 	}
+	return func(ctx context.Context, target reflect.Value, value string) error {
+		if err := ctx.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+		err := set(ctx, target, value)
+		if err != nil {
+			return setError(err, t, value, "")
+		}
+		return nil
+	}, nil
+}
+
+func makeStringSetter(t reflect.Type, opts stringSetterOpts) (func(ctx context.Context, target reflect.Value, value string) error, error) {
 	if opts.forceJSON {
-		return func(target reflect.Value, value string) error {
+		return func(_ context.Context, target reflect.Value, value string) error {
 			p := reflect.New(t.Elem())
 			target.Set(p)
 			err := json.Unmarshal([]byte(value), target.Interface())
@@ -88,18 +281,20 @@ func MakeStringSetter(t reflect.Type, optArgs ...StringSetterArg) (func(target r
 			return nil
 		}, nil
 	}
-	if setter, ok := settersByType[t]; ok {
-		return func(target reflect.Value, value string) error {
-			out := setter.Call([]reflect.Value{reflect.ValueOf(value)})
-			if !out[1].IsNil() {
-				return out[1].Interface().(error)
-			}
-			target.Set(out[0])
-			return nil
-		}, nil
+	if t == durationType && opts.durationStyle != DurationStandard {
+		return makeDurationSetter(opts)
+	}
+	if setter, ok := opts.registry.lookupSetter(t); ok {
+		return setter, nil
+	}
+	if t == timeType {
+		return makeTimeSetter(opts)
+	}
+	if t.Kind() == reflect.Ptr && t.Elem() == timeType {
+		return makePointerSetter(t, opts)
 	}
 	if t.AssignableTo(textUnmarshallerType) {
-		return func(target reflect.Value, value string) error {
+		return func(_ context.Context, target reflect.Value, value string) error {
 			p := reflect.New(t.Elem())
 			target.Set(p)
 			err := target.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
@@ -110,13 +305,13 @@ func MakeStringSetter(t reflect.Type, optArgs ...StringSetterArg) (func(target r
 		}, nil
 	}
 	if reflect.PtrTo(t).AssignableTo(textUnmarshallerType) {
-		return func(target reflect.Value, value string) error {
+		return func(_ context.Context, target reflect.Value, value string) error {
 			err := target.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
 			return errors.WithStack(err)
 		}, nil
 	}
 	if t.AssignableTo(flagValueType) {
-		return func(target reflect.Value, value string) error {
+		return func(_ context.Context, target reflect.Value, value string) error {
 			p := reflect.New(t.Elem())
 			target.Set(p)
 			err := target.Interface().(flag.Value).Set(value)
@@ -127,69 +322,69 @@ func MakeStringSetter(t reflect.Type, optArgs ...StringSetterArg) (func(target r
 		}, nil
 	}
 	if reflect.PtrTo(t).AssignableTo(flagValueType) {
-		return func(target reflect.Value, value string) error {
+		return func(_ context.Context, target reflect.Value, value string) error {
 			err := target.Addr().Interface().(flag.Value).Set(value)
 			return errors.WithStack(err)
 		}, nil
 	}
+	if opts.units != UnitsNone && isNumberKind(t.Kind()) {
+		return makeUnitsSetter(t, opts)
+	}
+	if opts.byteEncoding != BytesList && isByteSequence(t) {
+		return makeBytesSetter(t, opts)
+	}
 	switch t.Kind() {
 	case reflect.Ptr:
-		setElem, err := MakeStringSetter(t.Elem(), optArgs...)
-		if err != nil {
+		return makePointerSetter(t, opts)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if err := checkBase(opts.base); err != nil {
 			return nil, err
 		}
-		return func(target reflect.Value, value string) error {
-			p := reflect.New(t.Elem())
-			target.Set(p)
-			err := setElem(target.Elem(), value)
+		return func(_ context.Context, target reflect.Value, value string) error {
+			i, err := strconv.ParseInt(value, opts.base, t.Bits())
 			if err != nil {
-				return err
-			}
-			return nil
-		}, nil
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return func(target reflect.Value, value string) error {
-			i, err := strconv.ParseInt(value, 10, 64)
-			if err != nil {
-				return errors.WithStack(err)
+				return numberError(t, value, err)
 			}
 			target.SetInt(i)
 			return nil
 		}, nil
 	case reflect.Uint, reflect.Uintptr, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return func(target reflect.Value, value string) error {
-			i, err := strconv.ParseUint(value, 10, 64)
+		if err := checkBase(opts.base); err != nil {
+			return nil, err
+		}
+		return func(_ context.Context, target reflect.Value, value string) error {
+			i, err := strconv.ParseUint(value, opts.base, t.Bits())
 			if err != nil {
-				return errors.WithStack(err)
+				return numberError(t, value, err)
 			}
 			target.SetUint(i)
 			return nil
 		}, nil
 	case reflect.Float32, reflect.Float64:
-		return func(target reflect.Value, value string) error {
-			f, err := strconv.ParseFloat(value, 64)
+		return func(_ context.Context, target reflect.Value, value string) error {
+			f, err := strconv.ParseFloat(value, t.Bits())
 			if err != nil {
-				return errors.WithStack(err)
+				return numberError(t, value, err)
 			}
 			target.SetFloat(f)
 			return nil
 		}, nil
 	case reflect.String:
-		return func(target reflect.Value, value string) error {
+		return func(_ context.Context, target reflect.Value, value string) error {
 			target.SetString(value)
 			return nil
 		}, nil
 	case reflect.Complex64, reflect.Complex128:
-		return func(target reflect.Value, value string) error {
-			c, err := strconv.ParseComplex(value, 128)
+		return func(_ context.Context, target reflect.Value, value string) error {
+			c, err := strconv.ParseComplex(value, t.Bits())
 			if err != nil {
-				return errors.WithStack(err)
+				return numberError(t, value, err)
 			}
 			target.SetComplex(c)
 			return nil
 		}, nil
 	case reflect.Bool:
-		return func(target reflect.Value, value string) error {
+		return func(_ context.Context, target reflect.Value, value string) error {
 			b, err := strconv.ParseBool(value)
 			if err != nil {
 				return errors.WithStack(err)
@@ -198,51 +393,185 @@ func MakeStringSetter(t reflect.Type, optArgs ...StringSetterArg) (func(target r
 			return nil
 		}, nil
 	case reflect.Array:
-		setElem, err := MakeStringSetter(t.Elem())
+		setElem, err := makeStringSetter(t.Elem(), opts.elementOpts())
 		if err != nil {
 			return nil, err
 		}
 		if opts.split == "" {
-			return func(target reflect.Value, value string) error {
-				return setElem(target.Index(0), value)
+			if opts.strictArrays && t.Len() != 1 {
+				return nil, errors.Errorf("cannot strictly set %s without a separator", t)
+			}
+			return func(ctx context.Context, target reflect.Value, value string) error {
+				err := setElem(ctx, target.Index(0), value)
+				if err != nil {
+					return setError(err, t.Elem(), value, "[0]")
+				}
+				return nil
 			}, nil
 		}
-		return func(target reflect.Value, value string) error {
-			for i, v := range strings.SplitN(value, opts.split, target.Cap()) {
-				err := setElem(target.Index(i), v)
+		if err := opts.checkSplit(); err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, target reflect.Value, value string) error {
+			n := target.Len()
+			if opts.strictArrays {
+				n = -1
+			}
+			values, err := opts.splitValue(value, n)
+			if err != nil {
+				return err
+			}
+			if opts.strictArrays && len(values) != target.Len() {
+				return errors.Errorf("%d values provided but %s requires exactly %d", len(values), t, target.Len())
+			}
+			for i, v := range values {
+				err := setElem(ctx, target.Index(i), v)
 				if err != nil {
-					return err
+					return setError(err, t.Elem(), v, "["+strconv.Itoa(i)+"]")
 				}
 			}
 			return nil
 		}, nil
 	case reflect.Slice:
-		setElem, err := MakeStringSetter(t.Elem())
+		setElem, err := makeStringSetter(t.Elem(), opts.elementOpts())
 		if err != nil {
 			return nil, err
 		}
-		return func(target reflect.Value, value string) error {
+		if err := opts.checkSplit(); err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, target reflect.Value, value string) error {
 			var values []string
-			if opts.split != "" {
-				values = strings.Split(value, opts.split)
-			} else {
-				values = []string{value}
+			if value != "" {
+				var err error
+				values, err = opts.splitValue(value, -1)
+				if err != nil {
+					return err
+				}
+			}
+			a := reflect.Zero(target.Type())
+			if len(values) > 0 {
+				a = reflect.MakeSlice(target.Type(), len(values), len(values))
 			}
-			a := reflect.MakeSlice(target.Type(), len(values), len(values))
 			for i, v := range values {
-				err := setElem(a.Index(i), v)
+				err := setElem(ctx, a.Index(i), v)
+				if err != nil {
+					return setError(err, t.Elem(), v, "["+strconv.Itoa(i)+"]")
+				}
+			}
+			if !target.IsNil() && opts.sliceAppend {
+				a = reflect.AppendSlice(target, a)
+			}
+			if a.Len() < opts.sliceMin {
+				return errors.Errorf("%s requires at least %d values, got %d", t, opts.sliceMin, a.Len())
+			}
+			if opts.sliceMax > 0 && a.Len() > opts.sliceMax {
+				return errors.Errorf("%s allows at most %d values, got %d", t, opts.sliceMax, a.Len())
+			}
+			target.Set(a)
+			return nil
+		}, nil
+	case reflect.Map:
+		setKey, err := makeStringSetter(t.Key(), opts.mapElementOpts())
+		if err != nil {
+			return nil, err
+		}
+		setElem, err := makeStringSetter(t.Elem(), opts.mapElementOpts())
+		if err != nil {
+			return nil, err
+		}
+		if opts.kvSplit == "" {
+			return nil, errors.Errorf("cannot set %s: key/value separator is empty", t)
+		}
+		if err := opts.checkSplit(); err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, target reflect.Value, value string) error {
+			var entries []string
+			if value != "" {
+				var err error
+				entries, err = opts.splitValue(value, -1)
 				if err != nil {
 					return err
 				}
 			}
+			m := reflect.Zero(target.Type())
+			if len(entries) > 0 {
+				m = reflect.MakeMapWithSize(target.Type(), len(entries))
+			}
+			for _, entry := range entries {
+				k, v, ok := strings.Cut(entry, opts.kvSplit)
+				if !ok {
+					return errors.Errorf("map entry '%s' is missing key/value separator '%s'", entry, opts.kvSplit)
+				}
+				key := reflect.New(t.Key()).Elem()
+				err := setKey(ctx, key, k)
+				if err != nil {
+					return setError(err, t.Key(), k, "["+k+"]")
+				}
+				elem := reflect.New(t.Elem()).Elem()
+				err = setElem(ctx, elem, v)
+				if err != nil {
+					return setError(err, t.Elem(), v, "["+k+"]")
+				}
+				m.SetMapIndex(key, elem)
+			}
 			if target.IsNil() || !opts.sliceAppend {
-				target.Set(a)
+				target.Set(m)
 			} else {
-				target.Set(reflect.AppendSlice(target, a))
+				iter := m.MapRange()
+				for iter.Next() {
+					target.SetMapIndex(iter.Key(), iter.Value())
+				}
 			}
 			return nil
 		}, nil
+	case reflect.Struct:
+		if err := opts.checkSplit(); err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, target reflect.Value, value string) error {
+			elements, err := opts.splitValue(value, -1)
+			if err != nil {
+				return err
+			}
+			p := reflect.New(t)
+			p.Elem().Set(target)
+			err = fillStruct(ctx, p.Elem(), elements, fillOpt{
+				tag:           "pt",
+				kvSplit:       opts.kvSplit,
+				rejectUnknown: true,
+			}, opts.mapElementOpts())
+			if err != nil {
+				return err
+			}
+			target.Set(p.Elem())
+			return nil
+		}, nil
 	default:
 		return nil, errors.Errorf("type %s not supported", t)
 	}
 }
+
+func makePointerSetter(t reflect.Type, opts stringSetterOpts) (func(ctx context.Context, target reflect.Value, value string) error, error) {
+	setElem, err := makeStringSetter(t.Elem(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, target reflect.Value, value string) error {
+		p := reflect.New(t.Elem())
+		target.Set(p)
+		err := setElem(ctx, target.Elem(), value)
+		if err != nil {
+			return setError(err, t.Elem(), value, "")
+		}
+		return nil
+	}, nil
+}
+
+func checkBase(base int) error {
+	if base != 0 && (base < 2 || base > 36) {
+		return errors.Errorf("invalid integer base %d", base)
+	}
+	return nil
+}
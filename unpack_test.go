@@ -2,10 +2,12 @@ package reflectutils_test
 
 import (
 	"encoding"
+	"errors"
 	"flag"
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -93,8 +95,41 @@ func TestStringSetter(t *testing.T) {
 		SA3        [2]string       `value:"foo,bar"  want:"[foo,bar ]"  split:""`
 		SS5        []string        `value:"foo"      want:"[foo bar]"   value2:"bar"`
 		SS6        []string        `value:"foo"      want:"[bar]"       value2:"bar" sa:"f"`
+		SS7        []string        `value:""         want:"[]"`
+		SS8        []int           `value:"1"        want:"[1]"         value2:""`
 		RG01       *[]int          `value:"823:29"   want:"[823 29]"    split:":"`
 		S          *J              `value:"{\"A\":10,\"B\":\"bar\"}"  want:"{A:10 B:bar}" fj:"t"`
+		M1         map[string]int  `value:"a=1,b=2"  want:"map[a:1 b:2]"`
+		M2         map[string]int  `value:"a=1"      want:"map[a:1 b:2]"  value2:"b=2"`
+		M3         map[string]int  `value:"a=1"      want:"map[b:2]"      value2:"b=2" sa:"f"`
+		M4         map[int]string  `value:"1:a:b;2:" want:"map[1:a:b 2:]" split:";" kv:":"`
+		M5         map[string]Foo  `value:"a=1=2"    want:"map[a:~1=2~]"`
+		M6         map[string]int  `value:""         want:"map[]"`
+		M7         map[string]int  `value:"a=1"      want:"map[a:1]"      value2:""`
+		B1         uint32          `value:"0x1F"     want:"31"          base:"0"`
+		B2         []int           `value:"0o755,1_000,-0b11" want:"[493 1000 -3]" base:"0"`
+		B3         int8            `value:"-101"     want:"-5"          base:"2"`
+		B4         *uint16         `value:"ff"       want:"255"         base:"16"`
+		Q1         []string        `value:"\"x,y\",z"  want:"[x,y z]"     style:"csv"`
+		Q2         []string        `value:"a\\,b,c\\\\" want:"[a,b c\\]" style:"escaped"`
+		Q3         [2]string       `value:"a;\"b;c\"" want:"[a b;c]"  style:"csv" split:";"`
+		Q4         map[string]int  `value:"a\\,b=1,c=2" want:"map[a,b:1 c:2]" style:"escaped"`
+		N1         [][]string      `value:"a,b;c"    want:"[[a b] [c]]" levels:";|,"`
+		N5         [][]string      `value:"a\\;b;c\\\\,d" want:"[[a;b] [c\\ d]]" levels:";|," style:"escaped"`
+		N2         []map[int]int   `value:"1=2,3=4;5=6" want:"[map[1:2 3:4] map[5:6]]" levels:";|,"`
+		N3         [2][3]float64   `value:"1,2,3;4,5.5,6" want:"[[1 2 3] [4 5.5 6]]" levels:";"`
+		N4         map[int][]int   `value:"7=1:2,8=3" want:"map[7:[1 2] 8:[3]]" levels:",|:"`
+		U1         uint64          `value:"64MiB"    want:"67108864"    units:"bytes"`
+		U2         int             `value:"1.5k"     want:"1500"        units:"si"`
+		U3         []int64         `value:"10K,-2Gi" want:"[10000 -2147483648]" units:"si"`
+		U4         float64         `value:"1.5GB"    want:"1.5e+09"     units:"bytes"`
+		U5         *uint32         `value:"512B"     want:"512"         units:"bytes"`
+		U6         float32         `value:"2.5"      want:"2.5"         units:"si"`
+		E1         []byte          `value:"deadbeef" want:"[222 173 190 239]" enc:"hex"`
+		E2         [3]byte         `value:"AQID"     want:"[1 2 3]"     enc:"base64"`
+		E3         []byte          `value:"a,b"      want:"[97 44 98]"  enc:"string"`
+		E4         [][]byte        `value:"_w,-w"    want:"[[255] [251]]" enc:"base64rawurl"`
+		E5         []byte          `value:"1,2"      want:"[1 2]"`
 	}
 	var ts tsType
 	vp := reflect.ValueOf(&ts)
@@ -116,6 +151,33 @@ func TestStringSetter(t *testing.T) {
 				t.Log("  splitting on", split)
 				opts = append(opts, reflectutils.WithSplitOn(split))
 			}
+			if levels, ok := f.Tag.Lookup("levels"); ok {
+				split := strings.Split(levels, "|")
+				t.Log("  splitting levels", split)
+				opts = append(opts, reflectutils.WithSplitOnLevels(split[0], split[1:]...))
+			}
+			if kv, ok := f.Tag.Lookup("kv"); ok {
+				t.Log("  key/value split on", kv)
+				opts = append(opts, reflectutils.WithKeyValueSplitOn(kv))
+			}
+			if base, ok := f.Tag.Lookup("base"); ok {
+				b, err := strconv.Atoi(base)
+				require.NoError(t, err, "parse base")
+				t.Log("  base", b)
+				opts = append(opts, reflectutils.WithIntegerBase(b))
+			}
+			if style, ok := f.Tag.Lookup("style"); ok {
+				t.Log("  split style", style)
+				opts = append(opts, reflectutils.WithSplitStyle(splitStyles[style]))
+			}
+			if units, ok := f.Tag.Lookup("units"); ok {
+				t.Log("  units", units)
+				opts = append(opts, reflectutils.WithUnits(unitNames[units]))
+			}
+			if enc, ok := f.Tag.Lookup("enc"); ok {
+				t.Log("  byte encoding", enc)
+				opts = append(opts, reflectutils.WithByteEncoding(byteEncodings[enc]))
+			}
 			if sa, ok := f.Tag.Lookup("sa"); ok {
 				b, err := strconv.ParseBool(sa)
 				require.NoError(t, err, "parse sa")
@@ -154,3 +216,437 @@ func TestStringSetter(t *testing.T) {
 	})
 	assert.Equal(t, v.NumField(), count, "number of fields tested")
 }
+
+var unitNames = map[string]reflectutils.Units{
+	"si":    reflectutils.UnitsSI,
+	"bytes": reflectutils.UnitsBytes,
+}
+
+var byteEncodings = map[string]reflectutils.ByteEncoding{
+	"string":       reflectutils.BytesString,
+	"hex":          reflectutils.BytesHex,
+	"base64":       reflectutils.BytesBase64,
+	"base64url":    reflectutils.BytesBase64URL,
+	"base64raw":    reflectutils.BytesBase64Raw,
+	"base64rawurl": reflectutils.BytesBase64RawURL,
+}
+
+var splitStyles = map[string]reflectutils.SplitStyle{
+	"plain":   reflectutils.SplitPlain,
+	"csv":     reflectutils.SplitCSV,
+	"escaped": reflectutils.SplitEscaped,
+}
+
+func TestStringSetterSplitErrors(t *testing.T) {
+	var a [2]string
+	set, err := reflectutils.MakeStringSetter(reflect.TypeOf(a), reflectutils.WithSplitStyle(reflectutils.SplitCSV))
+	require.NoError(t, err)
+	require.Error(t, set(reflect.ValueOf(&a).Elem(), "a,b,c"), "too many for array")
+	require.Error(t, set(reflect.ValueOf(&a).Elem(), `"a,b`), "unterminated quote")
+
+	set, err = reflectutils.MakeStringSetter(reflect.TypeOf(a), reflectutils.WithSplitStyle(reflectutils.SplitEscaped))
+	require.NoError(t, err)
+	require.Error(t, set(reflect.ValueOf(&a).Elem(), `a,b\\,c`), "too many for array")
+	require.Error(t, set(reflect.ValueOf(&a).Elem(), `a\`), "trailing escape")
+
+	_, err = reflectutils.MakeStringSetter(reflect.TypeOf([]string{}),
+		reflectutils.WithSplitStyle(reflectutils.SplitCSV), reflectutils.WithSplitOn("::"))
+	require.Error(t, err, "multi-character csv separator")
+}
+
+func TestStringSetterNestedKeyValueSplit(t *testing.T) {
+	opts := []reflectutils.StringSetterArg{
+		reflectutils.WithSplitOnLevels(";", ","),
+		reflectutils.WithKeyValueSplitOn(":"),
+	}
+	var maps []map[string]int
+	set, err := reflectutils.MakeStringSetter(reflect.TypeOf(maps), opts...)
+	require.NoError(t, err)
+	require.NoError(t, set(reflect.ValueOf(&maps).Elem(), "a:1,b:2;c:3"))
+	assert.Equal(t, []map[string]int{{"a": 1, "b": 2}, {"c": 3}}, maps)
+	get, err := reflectutils.MakeStringGetter(reflect.TypeOf(maps), opts...)
+	require.NoError(t, err)
+	s, err := get(reflect.ValueOf(maps))
+	require.NoError(t, err)
+	assert.Equal(t, "a:1,b:2;c:3", s)
+
+	// once used, the separator goes back to the default
+	var nested map[string][]map[string]int
+	set, err = reflectutils.MakeStringSetter(reflect.TypeOf(nested),
+		reflectutils.WithSplitOnLevels(";", ",", " "), reflectutils.WithKeyValueSplitOn(":"))
+	require.NoError(t, err)
+	require.NoError(t, set(reflect.ValueOf(&nested).Elem(), "x:a=1 b=2,c=3"))
+	assert.Equal(t, map[string][]map[string]int{"x": {{"a": 1, "b": 2}, {"c": 3}}}, nested)
+}
+
+func TestStringSetterRange(t *testing.T) {
+	cases := []struct {
+		target interface{}
+		value  string
+	}{
+		{target: new(int8), value: "300"},
+		{target: new(int8), value: "-129"},
+		{target: new(uint16), value: "70000"},
+		{target: new(uint8), value: "256"},
+		{target: new(int32), value: "2147483648"},
+		{target: new(float32), value: "1e40"},
+		{target: new(float64), value: "1e400"},
+		{target: new(complex64), value: "1e40+1i"},
+		{target: new([]uint16), value: "1,70000"},
+	}
+	for _, tc := range cases {
+		v := reflect.ValueOf(tc.target).Elem()
+		t.Run(v.Type().String()+"-"+tc.value, func(t *testing.T) {
+			fn, err := reflectutils.MakeStringSetter(v.Type())
+			require.NoError(t, err)
+			err = fn(v, tc.value)
+			require.Error(t, err)
+			var rangeErr *reflectutils.RangeError
+			require.ErrorAs(t, err, &rangeErr)
+			assert.Equal(t, reflectutils.NonElement(v.Type()), rangeErr.Type)
+			assert.ErrorIs(t, err, strconv.ErrRange)
+			t.Log(err)
+		})
+	}
+	_, err := reflectutils.MakeStringSetter(reflect.TypeOf(0), reflectutils.WithIntegerBase(1))
+	require.Error(t, err, "invalid base")
+	var i8 int8
+	fn, err := reflectutils.MakeStringSetter(reflect.TypeOf(i8))
+	require.NoError(t, err)
+	require.NoError(t, fn(reflect.ValueOf(&i8).Elem(), "-128"))
+	assert.Equal(t, int8(-128), i8)
+}
+
+func TestStringSetterUnits(t *testing.T) {
+	cases := []struct {
+		target interface{}
+		value  string
+		units  reflectutils.Units
+		err    string
+	}{
+		{target: new(int8), value: "1k", units: reflectutils.UnitsSI, err: "out of range"},
+		{target: new(uint64), value: "16Ei", units: reflectutils.UnitsBytes, err: "out of range"},
+		{target: new(uint), value: "-1k", units: reflectutils.UnitsSI, err: "out of range"},
+		{target: new(float32), value: "1e30E", units: reflectutils.UnitsSI, err: "out of range"},
+		{target: new(int), value: "1.5", units: reflectutils.UnitsSI, err: "not a whole number"},
+		{target: new(int), value: "10MB", units: reflectutils.UnitsSI, err: "unknown unit"},
+		{target: new(int), value: "10X", units: reflectutils.UnitsBytes, err: "unknown unit"},
+		{target: new(int), value: "k", units: reflectutils.UnitsSI, err: "invalid number"},
+		{target: new(int), value: "1/2k", units: reflectutils.UnitsSI, err: "invalid number"},
+	}
+	for _, tc := range cases {
+		v := reflect.ValueOf(tc.target).Elem()
+		t.Run(v.Type().String()+"-"+tc.value, func(t *testing.T) {
+			fn, err := reflectutils.MakeStringSetter(v.Type(), reflectutils.WithUnits(tc.units))
+			require.NoError(t, err)
+			err = fn(v, tc.value)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+			if tc.err == "out of range" {
+				assert.ErrorIs(t, err, strconv.ErrRange)
+			}
+		})
+	}
+
+	var limits struct {
+		Memory int64   `pt:"memory,units=bytes"`
+		Rate   float64 `pt:"rate,units=si"`
+		Count  int     `pt:"count"`
+	}
+	require.NoError(t, reflectutils.Tag{Value: "memory=1.5GiB,rate=2.5k,count=7"}.Fill(&limits))
+	assert.Equal(t, int64(1610612736), limits.Memory)
+	assert.Equal(t, 2500.0, limits.Rate)
+	assert.Equal(t, 7, limits.Count)
+	require.Error(t, reflectutils.Tag{Value: "count=7k"}.Fill(&limits), "no units without units=")
+}
+
+func TestStringSetterBytes(t *testing.T) {
+	var key [4]byte
+	set, err := reflectutils.MakeStringSetter(reflect.TypeOf(key), reflectutils.WithByteEncoding(reflectutils.BytesHex))
+	require.NoError(t, err)
+	require.Error(t, set(reflect.ValueOf(&key).Elem(), "deadbe"), "too short")
+	require.Error(t, set(reflect.ValueOf(&key).Elem(), "deadbeef00"), "too long")
+	require.Error(t, set(reflect.ValueOf(&key).Elem(), "xyz"), "not hex")
+	_, err = reflectutils.MakeStringSetter(reflect.TypeOf(key), reflectutils.WithByteEncoding(reflectutils.ByteEncoding(99)))
+	require.Error(t, err, "invalid encoding")
+
+	type Salt []byte
+	var secrets struct {
+		Key  [4]byte `pt:"key,encoding=hex"`
+		Salt Salt    `pt:"salt,encoding=base64"`
+		Sum  []byte  `pt:"sum,encoding=base64url"`
+	}
+	require.NoError(t, reflectutils.Tag{Value: "key=DEADBEEF,salt=c2FsdA==,sum=-_8="}.Fill(&secrets))
+	assert.Equal(t, [4]byte{0xde, 0xad, 0xbe, 0xef}, secrets.Key)
+	assert.Equal(t, Salt("salt"), secrets.Salt)
+	assert.Equal(t, []byte{0xfb, 0xff}, secrets.Sum)
+	require.Error(t, reflectutils.Tag{Value: "salt=x"}.Fill(&secrets))
+}
+
+func TestStringSetterLengths(t *testing.T) {
+	strict := reflectutils.StrictArrayLength(true)
+	cases := []struct {
+		target interface{}
+		value  string
+		opts   []reflectutils.StringSetterArg
+		want   interface{}
+		err    string
+	}{
+		{target: new([2]int), value: "1,2", opts: []reflectutils.StringSetterArg{strict}, want: [2]int{1, 2}},
+		{target: new([2]int), value: "1,2,3", opts: []reflectutils.StringSetterArg{strict}, err: "3 values provided but [2]int requires exactly 2"},
+		{target: new([2]int), value: "1", opts: []reflectutils.StringSetterArg{strict}, err: "requires exactly 2"},
+		{target: new([2]string), value: "a,b,c", want: [2]string{"a", "b,c"}},
+		{target: new([2]string), value: "a,b,c", opts: []reflectutils.StringSetterArg{strict, reflectutils.WithSplitStyle(reflectutils.SplitCSV)}, err: "requires exactly 2"},
+		{target: new([][2]int), value: "1,2;3", opts: []reflectutils.StringSetterArg{strict, reflectutils.WithSplitOn(";")}, err: "[1]: cannot set [2]int"},
+		{target: new([]int), value: "1,2", opts: []reflectutils.StringSetterArg{reflectutils.WithSliceLengthLimits(2, 3)}, want: []int{1, 2}},
+		{target: new([]int), value: "1", opts: []reflectutils.StringSetterArg{reflectutils.WithSliceLengthLimits(2, 3)}, err: "at least 2"},
+		{target: new([]int), value: "1,2,3,4", opts: []reflectutils.StringSetterArg{reflectutils.WithSliceLengthLimits(2, 3)}, err: "at most 3"},
+		{target: new([]int), value: "1,2,3,4", opts: []reflectutils.StringSetterArg{reflectutils.WithSliceLengthLimits(1, 0)}, want: []int{1, 2, 3, 4}},
+		{target: new([][]int), value: "1;2", opts: []reflectutils.StringSetterArg{reflectutils.WithSliceLengthLimits(2, 2), reflectutils.WithSplitOn(";")}, want: [][]int{{1}, {2}}},
+	}
+	for _, tc := range cases {
+		v := reflect.ValueOf(tc.target).Elem()
+		t.Run(v.Type().String()+"-"+tc.value, func(t *testing.T) {
+			set, err := reflectutils.MakeStringSetter(v.Type(), tc.opts...)
+			require.NoError(t, err)
+			err = set(v, tc.value)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, v.Interface())
+		})
+	}
+
+	_, err := reflectutils.MakeStringSetter(reflect.TypeOf([2]int{}), strict, reflectutils.WithSplitOn(""))
+	require.Error(t, err, "no separator")
+
+	ints := []int{1, 2}
+	set, err := reflectutils.MakeStringSetter(reflect.TypeOf(ints), reflectutils.WithSliceLengthLimits(0, 3))
+	require.NoError(t, err)
+	require.Error(t, set(reflect.ValueOf(&ints).Elem(), "3,4"), "appended length is checked")
+	assert.Equal(t, []int{1, 2}, ints, "unchanged on error")
+
+	var model struct {
+		Pair  [2]int   `pt:"pair,split=space,strict"`
+		Hosts []string `pt:"hosts,split=space,min=1,max=2"`
+	}
+	require.NoError(t, reflectutils.Tag{Value: "pair=1 2,hosts=a b"}.Fill(&model))
+	assert.Equal(t, [2]int{1, 2}, model.Pair)
+	require.Error(t, reflectutils.Tag{Value: "pair=1 2 3"}.Fill(&model))
+	require.Error(t, reflectutils.Tag{Value: "hosts=a b c"}.Fill(&model))
+	require.Error(t, reflectutils.Tag{Value: "hosts=c"}.Fill(&model), "appending would exceed max")
+	require.NoError(t, reflectutils.Tag{Value: "pair=3 4"}.Fill(&model), "already has hosts")
+
+	model.Hosts = nil
+	err = reflectutils.Tag{Value: "pair=1 2"}.Fill(&model)
+	require.Error(t, err, "min applies to missing elements")
+	assert.Contains(t, err.Error(), "requires at least 1 values, got 0")
+	require.Error(t, reflectutils.Tag{Value: "pair=1 2,hosts="}.Fill(&model), "min applies to empty elements")
+
+	var other struct {
+		Arr [2]int `pt:"arr,min=1"`
+		N   int    `pt:"n,min=1"`
+	}
+	require.NoError(t, reflectutils.Tag{Value: ""}.Fill(&other), "min only applies to slices")
+}
+
+type Addr struct {
+	Host string   `pt:"host,required"`
+	Port int      `pt:"port"`
+	TLS  bool     `pt:"tls,!plain"`
+	Tags []string `pt:"tags"`
+	Note string
+}
+
+func TestStringSetterStruct(t *testing.T) {
+	cases := []struct {
+		value string
+		opts  []reflectutils.StringSetterArg
+		want  Addr
+		err   string
+	}{
+		{value: "host=db,port=5432", want: Addr{Host: "db", Port: 5432}},
+		{value: "host=db,plain,Note=hi", want: Addr{Host: "db", Note: "hi"}},
+		{value: "host=db,tls", want: Addr{Host: "db", TLS: true}},
+		{
+			value: "host:db;tags:a,b",
+			opts:  []reflectutils.StringSetterArg{reflectutils.WithSplitOn(";"), reflectutils.WithKeyValueSplitOn(":")},
+			want:  Addr{Host: "db", Tags: []string{"a", "b"}},
+		},
+		{value: "port=5432", err: "host is required"},
+		{value: "host=db,prot=5432", err: "unknown element(s): 'prot'"},
+		{value: "host=db,port=x", err: "Port"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			var got Addr
+			set, err := reflectutils.MakeStringSetter(reflect.TypeOf(got), tc.opts...)
+			require.NoError(t, err)
+			err = set(reflect.ValueOf(&got).Elem(), tc.value)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	var addrs []Addr
+	set, err := reflectutils.MakeStringSetter(reflect.TypeOf(addrs), reflectutils.WithSplitOnLevels(" ", ",", "/"))
+	require.NoError(t, err)
+	require.NoError(t, set(reflect.ValueOf(&addrs).Elem(), "host=a,tags=x/y host=b,port=2"))
+	assert.Equal(t, []Addr{{Host: "a", Tags: []string{"x", "y"}}, {Host: "b", Port: 2}}, addrs)
+}
+
+func TestStringSetterStructUnexported(t *testing.T) {
+	type inner struct {
+		Port int
+	}
+	type withUnexported struct {
+		Name string
+		note string
+		inner
+	}
+	set, err := reflectutils.MakeStringSetter(reflect.TypeOf(withUnexported{}))
+	require.NoError(t, err)
+	var v withUnexported
+	require.NoError(t, set(reflect.ValueOf(&v).Elem(), "Name=a,Port=2"))
+	assert.Equal(t, withUnexported{Name: "a", inner: inner{Port: 2}}, v)
+
+	err = set(reflect.ValueOf(&v).Elem(), "Name=a,note=b")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown element(s): 'note'")
+	assert.Equal(t, "", v.note)
+}
+
+func TestSetError(t *testing.T) {
+	type server struct {
+		Host  string         `pt:"host"`
+		Ports []int          `pt:"ports,split=space"`
+		Env   map[string]int `pt:"env,split=space"`
+	}
+	cases := []struct {
+		name   string
+		target interface{}
+		value  string
+		opts   []reflectutils.StringSetterArg
+		typ    reflect.Type
+		bad    string
+		path   string
+	}{
+		{name: "leaf", target: new(int), value: "x", typ: reflect.TypeOf(0), bad: "x"},
+		{name: "pointer", target: new(*int), value: "x", typ: reflect.TypeOf(0), bad: "x"},
+		{name: "slice", target: new([]int), value: "1,2,x", typ: reflect.TypeOf(0), bad: "x", path: "[2]"},
+		{name: "array", target: new([3]uint8), value: "1,300", typ: reflect.TypeOf(uint8(0)), bad: "300", path: "[1]"},
+		{name: "map key", target: new(map[int]string), value: "1=a,b=c", typ: reflect.TypeOf(0), bad: "b", path: "[b]"},
+		{name: "map value", target: new(map[string]int), value: "a=1,b=c", typ: reflect.TypeOf(0), bad: "c", path: "[b]"},
+		{name: "nested", target: new([][]bool), value: "t;t,f,maybe", opts: []reflectutils.StringSetterArg{reflectutils.WithSplitOnLevels(";", ",")}, typ: reflect.TypeOf(true), bad: "maybe", path: "[1][2]"},
+		{
+			name:   "struct",
+			target: new([]server),
+			value:  "host=a;host=b,ports=80 x",
+			opts:   []reflectutils.StringSetterArg{reflectutils.WithSplitOn(";")},
+			typ:    reflect.TypeOf(0),
+			bad:    "x",
+			path:   "[1].Ports[1]",
+		},
+		{
+			name:   "struct map",
+			target: new(server),
+			value:  "env=a=1 b=x",
+			typ:    reflect.TypeOf(0),
+			bad:    "x",
+			path:   "Env[b]",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := reflect.ValueOf(tc.target).Elem()
+			set, err := reflectutils.MakeStringSetter(v.Type(), tc.opts...)
+			require.NoError(t, err)
+			err = set(v, tc.value)
+			require.Error(t, err)
+			var setErr *reflectutils.SetError
+			require.ErrorAs(t, err, &setErr)
+			assert.Equal(t, tc.typ, setErr.Type, "type")
+			assert.Equal(t, tc.bad, setErr.Value, "value")
+			assert.Equal(t, tc.path, setErr.Path, "path")
+			require.Error(t, setErr.Err)
+			t.Log(err)
+		})
+	}
+
+	var s server
+	err := reflectutils.Tag{Value: "host=a,ports=1 2 three"}.Fill(&s)
+	var setErr *reflectutils.SetError
+	require.ErrorAs(t, err, &setErr)
+	assert.Equal(t, "Ports[2]", setErr.Path)
+	assert.Equal(t, "three", setErr.Value)
+	assert.Contains(t, err.Error(), `Ports[2] (pt:"ports,split=space"): cannot set int from 'three'`)
+	assert.Equal(t, reflectutils.Tag{Tag: "pt", Value: "ports,split=space"}, setErr.Tag)
+
+	var d struct {
+		Timeout time.Duration `default:"soon"`
+	}
+	err = reflectutils.FillInDefaultValues(&d)
+	require.ErrorAs(t, err, &setErr)
+	assert.Equal(t, "Timeout", setErr.Path)
+	assert.Equal(t, reflect.TypeOf(time.Duration(0)), setErr.Type)
+
+	var i8 int8
+	set, err := reflectutils.MakeStringSetter(reflect.TypeOf(i8))
+	require.NoError(t, err)
+	err = set(reflect.ValueOf(&i8).Elem(), "1000")
+	var rangeErr *reflectutils.RangeError
+	require.ErrorAs(t, err, &rangeErr, "SetError wraps RangeError")
+	require.ErrorAs(t, err, &setErr)
+}
+
+type Reading int
+
+func TestSetErrorNotModified(t *testing.T) {
+	shared := &reflectutils.SetError{Type: reflect.TypeOf(Reading(0)), Value: "bad", Err: errors.New("broken sensor")}
+	r := reflectutils.NewSetterRegistry(reflectutils.DefaultSetterRegistry())
+	reflectutils.RegisterStringSetterForIn(r, func(s string) (Reading, error) {
+		switch s {
+		case "bad":
+			return 0, shared
+		case "worse":
+			return 0, errors.Join(
+				&reflectutils.SetError{Type: reflect.TypeOf(0), Value: "a", Path: "[0]", Err: errors.New("low")},
+				errors.New("high"))
+		}
+		return 0, nil
+	})
+	type sensors struct {
+		Inside  Reading `pt:"inside"`
+		Outside Reading `pt:"outside"`
+	}
+	var s sensors
+	set, err := r.MakeStringSetter(reflect.TypeOf(s))
+	require.NoError(t, err)
+	for _, field := range []string{"Inside", "Outside"} {
+		err = set(reflect.ValueOf(&s).Elem(), strings.ToLower(field)+"=bad")
+		var setErr *reflectutils.SetError
+		require.ErrorAs(t, err, &setErr)
+		assert.Equal(t, field, setErr.Path)
+		assert.Equal(t, "", shared.Path, "shared error is not modified")
+		assert.Equal(t, "", shared.Tag.Tag, "shared error is not modified")
+	}
+
+	err = set(reflect.ValueOf(&s).Elem(), "outside=worse")
+	require.Error(t, err)
+	var paths []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var setErr *reflectutils.SetError
+		require.ErrorAs(t, e, &setErr)
+		paths = append(paths, setErr.Path)
+		assert.Equal(t, reflectutils.Tag{Tag: "pt", Value: "outside"}, setErr.Tag)
+	}
+	assert.Equal(t, []string{"Outside[0]", "Outside"}, paths, "each joined error has the path")
+}